@@ -2,12 +2,16 @@ package ginx
 
 import (
 	"context"
+	_ "embed"
 	"math"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/spaolacci/murmur3"
 )
 
+//go:embed bloom_filter.lua
+var bloomLua string
+
 // BloomFilter 基于Redis的布隆过滤器实现
 type BloomFilter struct {
 	cmd   redis.Cmdable // Redis客户端
@@ -32,23 +36,26 @@ func NewBloomFilter(cmd redis.Cmdable, key string, n uint, p float64) *BloomFilt
 	}
 }
 
-// Add 添加元素到布隆过滤器
+// Add 添加元素到布隆过滤器，Rebuild执行期间会同时写入影子键
 func (bf *BloomFilter) Add(ctx context.Context, data []byte) error {
-	for _, seed := range bf.seeds {
-		pos := bf.hash(data, seed)
-		_, err := bf.cmd.SetBit(ctx, bf.key, int64(pos), 1).Result()
-		if err != nil {
-			return err
-		}
+	offsets := bf.offsets(data)
+	args := make([]any, 0, len(offsets)+1)
+	args = append(args, "add")
+	for _, pos := range offsets {
+		args = append(args, pos)
 	}
-	return nil
+	return bf.eval(ctx, args...)
+}
+
+func (bf *BloomFilter) eval(ctx context.Context, args ...any) error {
+	keys := []string{bf.key, bf.shadowKey(), bf.rebuildMarkerKey()}
+	return bf.cmd.Eval(ctx, bloomLua, keys, args...).Err()
 }
 
 // Contains 检查元素是否可能存在
 func (bf *BloomFilter) Contains(ctx context.Context, data []byte) (bool, error) {
-	for _, seed := range bf.seeds {
-		pos := bf.hash(data, seed)
-		bit, err := bf.cmd.GetBit(ctx, bf.key, int64(pos)).Result()
+	for _, pos := range bf.offsets(data) {
+		bit, err := bf.cmd.GetBit(ctx, bf.key, pos).Result()
 		if err != nil {
 			return false, err
 		}
@@ -59,6 +66,15 @@ func (bf *BloomFilter) Contains(ctx context.Context, data []byte) (bool, error)
 	return true, nil
 }
 
+// offsets 计算元素对应的全部位偏移
+func (bf *BloomFilter) offsets(data []byte) []int64 {
+	res := make([]int64, 0, len(bf.seeds))
+	for _, seed := range bf.seeds {
+		res = append(res, int64(bf.hash(data, seed)))
+	}
+	return res
+}
+
 // hash 计算元素的哈希位置
 func (bf *BloomFilter) hash(data []byte, seed uint) uint {
 	h := murmur3.New32WithSeed(uint32(seed))
@@ -0,0 +1,28 @@
+-- 布隆过滤器写入与重建
+-- KEYS[1] 线上键，KEYS[2] 重建时的影子键，KEYS[3] 重建标记（存在表示正在重建）
+local key = KEYS[1]
+local shadow_key = KEYS[2]
+local marker_key = KEYS[3]
+-- 操作类型：add / swap
+local op = ARGV[1]
+
+if op == 'add' then
+    -- ARGV[2..] 位偏移；重建期间同时写入影子键，避免替换线上键时丢失重建过程中的写入
+    local rebuilding = redis.call('EXISTS', marker_key) == 1
+    for i = 2, #ARGV do
+        redis.call('SETBIT', key, ARGV[i], 1)
+        if rebuilding then
+            redis.call('SETBIT', shadow_key, ARGV[i], 1)
+        end
+    end
+    return 1
+end
+
+-- swap：结束重建，用影子键替换线上键；影子键不存在说明没有任何元素，直接清空线上键
+redis.call('DEL', marker_key)
+if redis.call('EXISTS', shadow_key) == 1 then
+    redis.call('RENAME', shadow_key, key)
+else
+    redis.call('DEL', key)
+end
+return 1
@@ -0,0 +1,114 @@
+package ginx
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// BloomStats 布隆过滤器运行状态
+type BloomStats struct {
+	M           uint    // 位数组大小
+	K           uint    // 哈希函数数量
+	BitsSet     int64   // 已置1的位数（BITCOUNT）
+	FillRatio   float64 // 位填充率
+	Cardinality float64 // 估算的元素数量
+	FPRate      float64 // 当前估算误判率
+}
+
+// Stats 统计布隆过滤器当前的填充率、估算元素数量及误判率
+func (bf *BloomFilter) Stats(ctx context.Context) (BloomStats, error) {
+	bits, err := bf.cmd.BitCount(ctx, bf.key, nil).Result()
+	if err != nil {
+		return BloomStats{}, err
+	}
+	stats := BloomStats{M: bf.m, K: bf.k, BitsSet: bits}
+	stats.FillRatio = float64(bits) / float64(bf.m)
+	// 基于填充率估算基数：n ≈ -(m/k)·ln(1 - X/m)
+	if stats.FillRatio >= 1 {
+		stats.Cardinality = math.Inf(1)
+	} else {
+		stats.Cardinality = -float64(bf.m) / float64(bf.k) * math.Log(1-stats.FillRatio)
+	}
+	// 误判率 ≈ (X/m)^k
+	stats.FPRate = math.Pow(stats.FillRatio, float64(bf.k))
+	return stats, nil
+}
+
+// KeyIterator 重建布隆过滤器时流式读取全部元素
+type KeyIterator interface {
+	// Next 返回下一个元素，ok为false表示已读取完毕
+	Next(ctx context.Context) (data []byte, ok bool, err error)
+}
+
+// KeyIteratorFunc 函数形式的KeyIterator
+type KeyIteratorFunc func(ctx context.Context) ([]byte, bool, error)
+
+func (f KeyIteratorFunc) Next(ctx context.Context) ([]byte, bool, error) {
+	return f(ctx)
+}
+
+const (
+	// rebuildBatchSize 重建时每批写入的元素数量
+	rebuildBatchSize = 512
+	// rebuildMarkerTTL 重建标记的过期时间，每批写入后续期，重建进程异常退出后Add不会一直双写
+	rebuildMarkerTTL = 5 * time.Minute
+)
+
+// Rebuild 从iter中读取全部元素写入影子键，完成后原子替换线上键，
+// 读请求在重建过程中始终访问旧数据，不会看到构建了一半的过滤器；
+// 重建期间的Add会同时写入影子键，因此替换后不会丢失并发写入
+// 适用于Redis数据被清空后的恢复以及服务启动时的预热
+// 注：同一过滤器同时只能执行一个Rebuild
+func (bf *BloomFilter) Rebuild(ctx context.Context, iter KeyIterator) (err error) {
+	shadow, marker := bf.shadowKey(), bf.rebuildMarkerKey()
+	if err = bf.cmd.Del(ctx, shadow).Err(); err != nil {
+		return err
+	}
+	if err = bf.cmd.Set(ctx, marker, 1, rebuildMarkerTTL).Err(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			bf.cmd.Del(ctx, marker, shadow)
+		}
+	}()
+	pending := 0
+	pipe := bf.cmd.Pipeline()
+	for {
+		data, ok, err := iter.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		for _, pos := range bf.offsets(data) {
+			pipe.SetBit(ctx, shadow, pos, 1)
+		}
+		pending++
+		if pending >= rebuildBatchSize {
+			pipe.Expire(ctx, marker, rebuildMarkerTTL)
+			if _, err = pipe.Exec(ctx); err != nil {
+				return err
+			}
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		if _, err = pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return bf.eval(ctx, "swap")
+}
+
+// shadowKey 重建时的影子键，与线上键在同一slot才能在Lua脚本中RENAME
+func (bf *BloomFilter) shadowKey() string {
+	return ClusterKey(bf.key, "rebuild")
+}
+
+// rebuildMarkerKey 重建标记，存在时Add同时写入影子键
+func (bf *BloomFilter) rebuildMarkerKey() string {
+	return ClusterKey(bf.key, "rebuilding")
+}
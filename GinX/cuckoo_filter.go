@@ -0,0 +1,119 @@
+package ginx
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spaolacci/murmur3"
+)
+
+//go:embed cuckoo_filter.lua
+var cuckooLua string
+
+// ErrCuckooFilterFull 过滤器已满，无法再插入元素
+var ErrCuckooFilterFull = errors.New("cuckoo filter已满")
+
+const (
+	// cuckooBucketSize 每个桶的槽位数
+	cuckooBucketSize = 4
+	// cuckooMaxKicks 插入时最大踢出次数
+	cuckooMaxKicks = 500
+	// cuckooLoadFactor 期望负载率
+	cuckooLoadFactor = 0.95
+)
+
+// CuckooFilter 基于Redis的布谷鸟过滤器实现
+// 使用16位指纹、每桶4个槽位，误判率约为 2*4/2^16 ≈ 0.012%，且支持删除，
+// 适用于频繁变更的黑白名单；踢出重定位在Lua脚本中原子完成
+type CuckooFilter struct {
+	cmd        redis.Cmdable // Redis客户端
+	tableKey   string        // 槽位表键名
+	countKey   string        // 元素计数键名
+	numBuckets uint32        // 桶数量（2的幂）
+}
+
+// NewCuckooFilter 创建布谷鸟过滤器
+// n: 预期元素数量
+func NewCuckooFilter(cmd redis.Cmdable, key string, n uint) *CuckooFilter {
+	return &CuckooFilter{
+		cmd:        cmd,
+		tableKey:   ClusterKey(key, "table"),
+		countKey:   ClusterKey(key, "count"),
+		numBuckets: calculateBuckets(n),
+	}
+}
+
+// Add 添加元素到布谷鸟过滤器，过滤器已满时返回ErrCuckooFilterFull
+// 注：与布隆过滤器不同，重复添加同一元素会占用多个槽位，删除时也需要删除对应次数
+func (cf *CuckooFilter) Add(ctx context.Context, data []byte) error {
+	res, err := cf.eval(ctx, "add", data)
+	if err != nil {
+		return err
+	}
+	if !res {
+		return ErrCuckooFilterFull
+	}
+	return nil
+}
+
+// Contains 检查元素是否可能存在
+func (cf *CuckooFilter) Contains(ctx context.Context, data []byte) (bool, error) {
+	return cf.eval(ctx, "contains", data)
+}
+
+// Delete 删除元素，返回元素是否存在
+// 注：只能删除确定添加过的元素，否则可能误删指纹相同的其他元素
+func (cf *CuckooFilter) Delete(ctx context.Context, data []byte) (bool, error) {
+	return cf.eval(ctx, "delete", data)
+}
+
+// Count 返回当前过滤器中的元素数量
+func (cf *CuckooFilter) Count(ctx context.Context) (int64, error) {
+	cnt, err := cf.cmd.Get(ctx, cf.countKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return cnt, err
+}
+
+func (cf *CuckooFilter) eval(ctx context.Context, op string, data []byte) (bool, error) {
+	i1, i2, fp := cf.locate(data)
+	res, err := cf.cmd.Eval(ctx, cuckooLua, []string{cf.tableKey, cf.countKey},
+		op, i1, i2, fp, cuckooBucketSize, cf.numBuckets, cuckooMaxKicks, rand.Intn(math.MaxInt32)).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// locate 计算元素的两个候选桶及指纹
+func (cf *CuckooFilter) locate(data []byte) (uint32, uint32, uint16) {
+	h := murmur3.Sum64(data)
+	fp := uint16(h >> 48)
+	if fp == 0 {
+		fp = 1 // 0表示空槽
+	}
+	i1 := uint32(h) & (cf.numBuckets - 1)
+	return i1, cf.altIndex(i1, fp), fp
+}
+
+// altIndex 计算备用桶下标（部分键布谷鸟哈希），需与Lua脚本中的alt保持一致
+// 桶数量为2的幂，因此uint32溢出回绕不影响取模结果
+func (cf *CuckooFilter) altIndex(i uint32, fp uint16) uint32 {
+	mask := cf.numBuckets - 1
+	return (uint32(fp)*0x5bd1e995&mask - i) & mask
+}
+
+// calculateBuckets 计算桶数量（向上取2的幂）
+func calculateBuckets(n uint) uint32 {
+	need := uint32(math.Ceil(float64(n) / cuckooBucketSize / cuckooLoadFactor))
+	buckets := uint32(1)
+	for buckets < need {
+		buckets <<= 1
+	}
+	return buckets
+}
@@ -0,0 +1,104 @@
+-- 布谷鸟过滤器：槽位表为字符串，每个槽位占2字节存储16位指纹（大端序），0表示空槽
+-- KEYS[1] 槽位表，KEYS[2] 元素计数
+local table_key = KEYS[1]
+local count_key = KEYS[2]
+-- 操作类型：add / contains / delete
+local op = ARGV[1]
+local i1 = tonumber(ARGV[2])
+local i2 = tonumber(ARGV[3])
+local fp = tonumber(ARGV[4])
+-- 每个桶的槽位数
+local b = tonumber(ARGV[5])
+-- 桶数量（2的幂）
+local n_buckets = tonumber(ARGV[6])
+-- 最大踢出次数
+local max_kicks = tonumber(ARGV[7])
+-- 随机数，用于选择被踢出的槽位
+local r = tonumber(ARGV[8])
+
+local function get(i, s)
+    local offset = (i * b + s) * 2
+    local v = redis.call('GETRANGE', table_key, offset, offset + 1)
+    if #v < 2 then
+        return 0
+    end
+    return string.byte(v, 1) * 256 + string.byte(v, 2)
+end
+
+local function set(i, s, f)
+    local offset = (i * b + s) * 2
+    redis.call('SETRANGE', table_key, offset, string.char(math.floor(f / 256), f % 256))
+end
+
+-- 在桶i中查找指纹f，返回槽位下标，找不到返回-1
+local function find(i, f)
+    for s = 0, b - 1 do
+        if get(i, s) == f then
+            return s
+        end
+    end
+    return -1
+end
+
+-- 备用桶下标：alt(alt(i)) == i，与客户端计算方式保持一致
+local function alt(i, f)
+    return ((f * 0x5bd1e995) % n_buckets - i) % n_buckets
+end
+
+if op == 'contains' then
+    if find(i1, fp) >= 0 or find(i2, fp) >= 0 then
+        return 1
+    end
+    return 0
+end
+
+if op == 'delete' then
+    for _, i in ipairs({ i1, i2 }) do
+        local s = find(i, fp)
+        if s >= 0 then
+            set(i, s, 0)
+            redis.call('DECR', count_key)
+            return 1
+        end
+    end
+    return 0
+end
+
+-- add：优先放入两个候选桶的空槽
+for _, i in ipairs({ i1, i2 }) do
+    local s = find(i, 0)
+    if s >= 0 then
+        set(i, s, fp)
+        redis.call('INCR', count_key)
+        return 1
+    end
+end
+
+-- 两个桶都已满，执行踢出重定位，并记录修改以便失败时回滚
+local i = i1
+if r % 2 == 1 then
+    i = i2
+end
+local f = fp
+local changes = {}
+for n = 1, max_kicks do
+    local s = (r + n) % b
+    local victim = get(i, s)
+    set(i, s, f)
+    table.insert(changes, { i, s, victim })
+    f = victim
+    i = alt(i, f)
+    local empty = find(i, 0)
+    if empty >= 0 then
+        set(i, empty, f)
+        redis.call('INCR', count_key)
+        return 1
+    end
+end
+
+-- 过滤器已满，回滚全部踢出操作
+for n = #changes, 1, -1 do
+    local c = changes[n]
+    set(c[1], c[2], c[3])
+end
+return 0
@@ -0,0 +1,35 @@
+package ginx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// luaAlt 按Lua 5.1的语义（double运算、%结果与除数同号）计算cuckoo_filter.lua中的alt
+func luaAlt(i uint32, fp uint16, numBuckets uint32) uint32 {
+	mod := func(a, b float64) float64 {
+		return a - math.Floor(a/b)*b
+	}
+	n := float64(numBuckets)
+	return uint32(mod(mod(float64(fp)*0x5bd1e995, n)-float64(i), n))
+}
+
+func TestCuckooFilterAltIndex(t *testing.T) {
+	for shift := 0; shift <= 24; shift++ {
+		cf := &CuckooFilter{numBuckets: 1 << shift}
+		indexes := []uint32{0, 1, cf.numBuckets / 2, cf.numBuckets - 1}
+		for fp := 1; fp <= math.MaxUint16; fp += 7 {
+			for _, i := range indexes {
+				i &= cf.numBuckets - 1
+				alt := cf.altIndex(i, uint16(fp))
+				if !assert.Less(t, alt, cf.numBuckets) ||
+					!assert.Equal(t, i, cf.altIndex(alt, uint16(fp)), "alt(alt(i))应等于i") ||
+					!assert.Equal(t, luaAlt(i, uint16(fp), cf.numBuckets), alt, "需与Lua脚本的alt一致") {
+					t.Fatalf("numBuckets=%d i=%d fp=%d", cf.numBuckets, i, fp)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package ginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClusterKey 生成以tag为hash tag的Redis键：{tag}:part1:part2...
+// 同一tag下的键在Redis Cluster中落在同一slot，多键Lua脚本、RENAME等操作才能执行
+func ClusterKey(tag string, parts ...any) string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	sb.WriteString(tag)
+	sb.WriteString("}")
+	for _, part := range parts {
+		sb.WriteString(":")
+		sb.WriteString(fmt.Sprint(part))
+	}
+	return sb.String()
+}
@@ -0,0 +1,104 @@
+package ginx
+
+import (
+	"context"
+	_ "embed"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed rotating_bloom_filter.lua
+var rotatingBloomLua string
+
+// RotatingBloomFilter 基于时间分桶的布隆过滤器
+// 每个时间桶是一个独立的Redis位图，依赖TTL自动淘汰窗口外的旧桶，
+// 适用于在滑动时间窗口内对Kafka消息、通知发送等做去重
+type RotatingBloomFilter struct {
+	bf          *BloomFilter  // 复用哈希参数（m、k、seeds）
+	interval    time.Duration // 单个桶的时间跨度
+	bucketCount int           // 窗口内保留的桶数量
+	now         func() time.Time
+}
+
+// NewRotatingBloomFilter 创建时间分桶布隆过滤器
+// n: 单个桶内预期元素数量
+// p: 期望的误判率（0 < p < 1）
+// interval: 单个桶的时间跨度，如time.Hour，非正数时按time.Hour处理
+// bucketCount: 窗口内的桶数量，如24（即最近24小时）
+func NewRotatingBloomFilter(cmd redis.Cmdable, key string, n uint, p float64,
+	interval time.Duration, bucketCount int) *RotatingBloomFilter {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	if bucketCount <= 0 {
+		bucketCount = 1
+	}
+	return &RotatingBloomFilter{
+		bf:          NewBloomFilter(cmd, key, n, p),
+		interval:    interval,
+		bucketCount: bucketCount,
+		now:         time.Now,
+	}
+}
+
+// Add 添加元素到当前时间桶
+func (rbf *RotatingBloomFilter) Add(ctx context.Context, data []byte) error {
+	key := rbf.bucketKey(rbf.now())
+	pipe := rbf.bf.cmd.Pipeline()
+	for _, pos := range rbf.bf.offsets(data) {
+		pipe.SetBit(ctx, key, pos, 1)
+	}
+	pipe.PExpire(ctx, key, rbf.ttl())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Contains 检查元素在窗口内是否可能存在
+func (rbf *RotatingBloomFilter) Contains(ctx context.Context, data []byte) (bool, error) {
+	return rbf.eval(ctx, data, false)
+}
+
+// AddIfAbsent 原子地检查并添加元素
+// 返回true表示元素此前不存在且已写入当前桶，false表示窗口内可能已存在（应视为重复）
+func (rbf *RotatingBloomFilter) AddIfAbsent(ctx context.Context, data []byte) (bool, error) {
+	exists, err := rbf.eval(ctx, data, true)
+	return !exists, err
+}
+
+func (rbf *RotatingBloomFilter) eval(ctx context.Context, data []byte, add bool) (bool, error) {
+	flag := "0"
+	if add {
+		flag = "1"
+	}
+	offsets := rbf.bf.offsets(data)
+	args := make([]any, 0, len(offsets)+2)
+	args = append(args, rbf.ttl().Milliseconds(), flag)
+	for _, pos := range offsets {
+		args = append(args, pos)
+	}
+	res, err := rbf.bf.cmd.Eval(ctx, rotatingBloomLua, rbf.bucketKeys(rbf.now()), args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// ttl 桶的存活时间：保证桶在整个窗口内可见
+func (rbf *RotatingBloomFilter) ttl() time.Duration {
+	return rbf.interval * time.Duration(rbf.bucketCount+1)
+}
+
+// bucketKeys 返回窗口内全部桶键，第一个为当前桶
+func (rbf *RotatingBloomFilter) bucketKeys(now time.Time) []string {
+	keys := make([]string, 0, rbf.bucketCount)
+	for i := 0; i < rbf.bucketCount; i++ {
+		keys = append(keys, rbf.bucketKey(now.Add(-time.Duration(i)*rbf.interval)))
+	}
+	return keys
+}
+
+// bucketKey 计算时间t所在桶的键名
+func (rbf *RotatingBloomFilter) bucketKey(t time.Time) string {
+	return ClusterKey(rbf.bf.key, t.UnixNano()/int64(rbf.interval))
+}
@@ -0,0 +1,28 @@
+-- 时间分桶布隆过滤器：KEYS[1]为当前桶，其余为仍在窗口内的历史桶
+-- 过期时间（毫秒）
+local ttl = tonumber(ARGV[1])
+-- 是否在不存在时写入当前桶（1写入，0只检查）
+local add = ARGV[2] == '1'
+
+-- 任意一个桶中全部位都为1即认为元素可能存在
+for _, key in ipairs(KEYS) do
+    local hit = true
+    for i = 3, #ARGV do
+        if redis.call('GETBIT', key, ARGV[i]) == 0 then
+            hit = false
+            break
+        end
+    end
+    if hit then
+        return 1
+    end
+end
+
+if add then
+    local current = KEYS[1]
+    for i = 3, #ARGV do
+        redis.call('SETBIT', current, ARGV[i], 1)
+    end
+    redis.call('PEXPIRE', current, ttl)
+end
+return 0
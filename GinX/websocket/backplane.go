@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// Backplane 跨实例消息总线，多实例部署时将投递扇出到集群内全部实例
+type Backplane interface {
+	// Publish 发布投递
+	Publish(ctx context.Context, d *Delivery) error
+	// Subscribe 订阅投递，阻塞直到ctx结束，订阅断开时应自动重连
+	Subscribe(ctx context.Context, handler func(d *Delivery)) error
+}
+
+const (
+	// minReconnectInterval 订阅断开后的最小重连间隔
+	minReconnectInterval = 100 * time.Millisecond
+	// maxReconnectInterval 订阅断开后的最大重连间隔
+	maxReconnectInterval = 5 * time.Second
+)
+
+// RedisBackplane 基于Redis pub/sub的消息总线
+type RedisBackplane struct {
+	l       loggerx.Logger
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewRedisBackplane 创建基于Redis pub/sub的消息总线
+// 传单机、哨兵或cluster Redis都可以
+func NewRedisBackplane(l loggerx.Logger, client redis.UniversalClient, channel string) *RedisBackplane {
+	return &RedisBackplane{
+		l:       l,
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (b *RedisBackplane) Publish(ctx context.Context, d *Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+func (b *RedisBackplane) Subscribe(ctx context.Context, handler func(d *Delivery)) error {
+	return subscribeRedis(ctx, b.l, b.client, b.channel, func(payload string) {
+		var d Delivery
+		if err := json.Unmarshal([]byte(payload), &d); err != nil {
+			b.l.Error("websocket消息总线反序列化失败", loggerx.Error(err))
+			return
+		}
+		handler(&d)
+	})
+}
+
+// subscribeRedis 订阅Redis频道，订阅断开时按指数退避自动重连，阻塞直到ctx结束
+func subscribeRedis(ctx context.Context, l loggerx.Logger, client redis.UniversalClient, channel string, handler func(payload string)) error {
+	interval := minReconnectInterval
+	for {
+		err := receiveRedis(ctx, client, channel, handler, func() {
+			// 订阅成功后重置重连间隔
+			interval = minReconnectInterval
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.Warn("websocket Redis订阅断开，准备重连", loggerx.Error(err),
+			loggerx.String("channel", channel),
+			loggerx.String("interval", interval.String()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		// 指数退避
+		interval *= 2
+		if interval > maxReconnectInterval {
+			interval = maxReconnectInterval
+		}
+	}
+}
+
+// receiveRedis 建立一次订阅并持续接收消息，直到出错或ctx结束
+func receiveRedis(ctx context.Context, client redis.UniversalClient, channel string, handler func(payload string), onSubscribed func()) error {
+	pubsub := client.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	// 等待订阅确认
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	onSubscribed()
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		handler(msg.Payload)
+	}
+}
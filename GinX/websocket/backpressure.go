@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// BackpressurePolicy 客户端发送队列已满（慢消费者）时的处理策略
+type BackpressurePolicy int
+
+const (
+	// PolicyDisconnect 断开客户端（默认）
+	PolicyDisconnect BackpressurePolicy = iota
+	// PolicyDropNewest 丢弃当前消息
+	PolicyDropNewest
+	// PolicyDropOldest 丢弃队列中最早的消息，再放入当前消息
+	PolicyDropOldest
+	// PolicyBlock 阻塞等待队列空出，超时后断开客户端
+	// 注：阻塞发生在Manager事件循环中，会拖慢全部投递，超时时间应尽量短
+	PolicyBlock
+)
+
+// SlowConsumerAction 对慢消费者实际执行的动作
+type SlowConsumerAction string
+
+const (
+	ActionDisconnect SlowConsumerAction = "disconnect"  // 断开连接
+	ActionDropNewest SlowConsumerAction = "drop_newest" // 丢弃了当前消息
+	ActionDropOldest SlowConsumerAction = "drop_oldest" // 丢弃了队列中最早的消息
+)
+
+// SlowConsumerHook 慢消费者钩子，每次丢弃或断开时调用，message为被丢弃的消息（断开时为当前消息）
+// 注：钩子在Manager事件循环中同步调用，不能在其中调用Manager的方法，耗时操作请异步处理
+type SlowConsumerHook func(client *Client, action SlowConsumerAction, message *Message)
+
+// WithBackpressure 设置慢消费者处理策略，blockTimeout只在PolicyBlock下生效
+func WithBackpressure(policy BackpressurePolicy, blockTimeout time.Duration) ManagerOption {
+	return func(manager *Manager) {
+		manager.options.backpressure = policy
+		manager.options.blockTimeout = blockTimeout
+	}
+}
+
+// WithSlowConsumerHook 设置慢消费者钩子，可用于记录日志与监控
+func WithSlowConsumerHook(hook SlowConsumerHook) ManagerOption {
+	return func(manager *Manager) {
+		manager.options.slowConsumerHook = hook
+	}
+}
+
+// send 向客户端投递消息，发送队列已满时按策略处理（调用方需持有写锁）
+func (manager *Manager) send(client *Client, message *Message) {
+	select {
+	case client.Send <- message:
+		return
+	default:
+	}
+	switch manager.options.backpressure {
+	case PolicyDropNewest:
+		manager.slowConsumer(client, ActionDropNewest, message)
+	case PolicyDropOldest:
+		select {
+		case oldest := <-client.Send:
+			manager.slowConsumer(client, ActionDropOldest, oldest)
+		default:
+		}
+		select {
+		case client.Send <- message:
+		default:
+			// 写协程与其他投递竞争后队列仍满，丢弃当前消息
+			manager.slowConsumer(client, ActionDropNewest, message)
+		}
+	case PolicyBlock:
+		timer := time.NewTimer(manager.options.blockTimeout)
+		defer timer.Stop()
+		select {
+		case client.Send <- message:
+		case <-timer.C:
+			manager.disconnectSlow(client, message)
+		}
+	default:
+		manager.disconnectSlow(client, message)
+	}
+}
+
+// disconnectSlow 断开慢消费者（调用方需持有写锁）
+func (manager *Manager) disconnectSlow(client *Client, message *Message) {
+	client.closeWith(websocket.CloseTryAgainLater, "send buffer full")
+	manager.remove(client)
+	manager.slowConsumer(client, ActionDisconnect, message)
+}
+
+func (manager *Manager) slowConsumer(client *Client, action SlowConsumerAction, message *Message) {
+	if manager.options.slowConsumerHook != nil {
+		manager.options.slowConsumerHook(client, action, message)
+	}
+}
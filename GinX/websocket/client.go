@@ -2,62 +2,136 @@ package websocket
 
 import (
 	"bytes"
+	"context"
 	"github.com/gorilla/websocket"
-	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
-const (
-	// writeTimeOut 数据写入时间限制
-	writeTimeOut = 10 * time.Second
-	// readTimeOut 数据读取时间限制
-	readTimeOut = 60 * time.Second
-	// heartBeatTime 心跳检测时间
-	heartBeatTime = (readTimeOut * 9) / 10
-	// 最大消息大小(字节)
-	maxMessageSize = 512
-)
-
 var (
 	LINE  = []byte{'\n'}
 	SPACE = []byte{' '}
 )
 
-// upgrader websocket升级
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// 数据帧类型
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+// 传输方式
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+)
+
+// Message 出站消息，携带帧类型以便文本帧与二进制帧混合发送
+type Message struct {
+	Type int // TextMessage 或 BinaryMessage
+	Data []byte
+	Seq  uint64 // 可靠消息的序号，SSE中作为事件id
+
+	// batch 可与相邻的文本消息以换行合并为一帧，只用于Broadcast通道中已将换行替换为空格的消息；
+	// 其余消息（如消息信封）总是单独成帧，客户端可以逐帧解析
+	batch bool
+}
+
+// NewTextMessage 创建文本消息
+func NewTextMessage(data []byte) *Message {
+	return &Message{Type: TextMessage, Data: data}
+}
+
+// NewBinaryMessage 创建二进制消息（如protobuf负载）
+func NewBinaryMessage(data []byte) *Message {
+	return &Message{Type: BinaryMessage, Data: data}
 }
 
 // Client websocket客户端
 type Client struct {
 	Manager *Manager
-	Conn    *websocket.Conn
-	Send    chan []byte
+	Conn    *websocket.Conn // SSE客户端为nil
+	Send    chan *Message
+	UID     int64  // 绑定的用户ID，0表示匿名连接
+	ID      string // 连接ID，用于日志
+
+	Transport  string // 传输方式：TransportWebSocket 或 TransportSSE
+	RemoteAddr string // 客户端地址
+
+	rooms    map[string]struct{} // 已加入的房间，由Manager维护
+	limiter  *inboundLimiter     // 连接级上行限流器，为nil时不限流
+	lastWarn time.Time           // 最近一次发送限流警告帧的时间
+	lastSeq  uint64              // 客户端最后收到的可靠消息序号
+	resume   bool                // 是否需要补发lastSeq之后的消息
+	replay   []OutboxEntry       // 重连补发的消息，由写协程先于发送队列中的实时消息写入
+
+	closeMu     sync.Mutex
+	closeCode   int    // 关闭帧状态码，为0时发送空关闭帧
+	closeReason string // 关闭帧原因
+
+	ctx      context.Context // 连接断开时取消
+	cancel   context.CancelFunc
+	rpcMu    sync.Mutex
+	calls    map[string]chan *Event        // 服务端发起、等待响应的调用
+	inflight map[string]context.CancelFunc // 客户端发起、正在处理的调用
 }
 
-// NewClient 创建websocket客户端
-func NewClient(manager *Manager, response http.ResponseWriter, request *http.Request) (*Client, error) {
-	conn, err := upgrader.Upgrade(response, request, nil)
+// ClientOption 客户端配置选项
+type ClientOption func(*Client)
+
+// WithUID 绑定用户ID，通常在HTTP升级时从jwtx.UserClaims.UID获取：
+//
+//	claims := ctx.MustGet("claims").(*jwtx.UserClaims)
+//	websocket.DefaultServeWs(manager, ctx.Writer, ctx.Request, websocket.WithUID(claims.UID))
+func WithUID(uid int64) ClientOption {
+	return func(client *Client) {
+		client.UID = uid
+	}
+}
+
+// NewClient 创建websocket客户端（读写协程由ServeWs/DefaultServeWs启动）
+func NewClient(manager *Manager, response http.ResponseWriter, request *http.Request, opts ...ClientOption) (*Client, error) {
+	if manager.closing.Load() {
+		return nil, ErrManagerClosed
+	}
+	conn, err := manager.upgrader.Upgrade(response, request, nil)
 	if err != nil {
 		return nil, err
 	}
-	client := &Client{
-		Manager: manager,
-		Conn:    conn,
-		Send:    make(chan []byte, 256),
+	client := newClient(manager, TransportWebSocket, request)
+	client.Conn = conn
+	for _, opt := range opts {
+		opt(client)
+	}
+	manager.prepareReplay(request, client)
+	if err = manager.register(client); err != nil {
+		return nil, err
 	}
-	client.Manager.Register <- client
+	manager.replayGap(request.Context(), client)
+	manager.onConnect(client)
 	return client, nil
 }
 
+// newClient 创建未绑定底层连接的客户端
+func newClient(manager *Manager, transport string, request *http.Request) *Client {
+	client := &Client{
+		Manager:    manager,
+		Send:       make(chan *Message, manager.options.sendBufferSize),
+		ID:         newID(),
+		Transport:  transport,
+		RemoteAddr: request.RemoteAddr,
+		rooms:      make(map[string]struct{}),
+		calls:      make(map[string]chan *Event),
+		inflight:   make(map[string]context.CancelFunc),
+		limiter:    newInboundLimiter(manager.options.connRateLimit, manager.options.maxMessageSize),
+	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	return client
+}
+
 // DefaultServeWs 处理websocket请求
-func DefaultServeWs(manager *Manager, response http.ResponseWriter, request *http.Request) error {
-	client, err := NewClient(manager, response, request)
+func DefaultServeWs(manager *Manager, response http.ResponseWriter, request *http.Request, opts ...ClientOption) error {
+	client, err := NewClient(manager, response, request, opts...)
 	if err != nil {
 		return err
 	}
@@ -67,8 +141,8 @@ func DefaultServeWs(manager *Manager, response http.ResponseWriter, request *htt
 }
 
 // ServeWs 处理websocket请求
-func ServeWs(manager *Manager, response http.ResponseWriter, request *http.Request, readFunc func(client *Client, messageType int, message []byte, err error) error, writeFunc func(client *Client, messageType int, message []byte) error) error {
-	client, err := NewClient(manager, response, request)
+func ServeWs(manager *Manager, response http.ResponseWriter, request *http.Request, readFunc func(client *Client, messageType int, message []byte, err error) error, writeFunc func(client *Client, messageType int, message []byte) error, opts ...ClientOption) error {
+	client, err := NewClient(manager, response, request, opts...)
 	if err != nil {
 		return err
 	}
@@ -78,7 +152,9 @@ func ServeWs(manager *Manager, response http.ResponseWriter, request *http.Reque
 		go client.defaultRead()
 	}
 	if writeFunc != nil {
-		go client.write(writeFunc)
+		go client.write(func(client *Client, message *Message) error {
+			return writeFunc(client, message.Type, message.Data)
+		})
 	} else {
 		go client.defaultWrite()
 	}
@@ -86,28 +162,43 @@ func ServeWs(manager *Manager, response http.ResponseWriter, request *http.Reque
 }
 
 // write 写入数据
-func (client *Client) write(fn func(client *Client, messageType int, message []byte) error) {
-	ticker := time.NewTicker(heartBeatTime)
+func (client *Client) write(fn func(client *Client, message *Message) error) {
+	opts := &client.Manager.options
+	ticker := time.NewTicker(opts.heartBeatTime())
 	defer func() {
 		ticker.Stop()
 		client.Conn.Close()
+		client.Manager.writers.Done()
 	}()
+	// 先写入重连补发的消息，再写入发送队列中的实时消息
+	for len(client.replay) > 0 {
+		entry := client.replay[0]
+		client.replay = client.replay[1:]
+		client.Conn.SetWriteDeadline(time.Now().Add(opts.writeTimeout))
+		if err := fn(client, entry.message()); err != nil {
+			client.Manager.onError(client, "websocket写入数据失败", err)
+			return
+		}
+	}
+	client.replay = nil
 	for {
 		select {
 		case message, ok := <-client.Send:
 			// 设置写入截止时间，防止超时
-			client.Conn.SetWriteDeadline(time.Now().Add(writeTimeOut))
+			client.Conn.SetWriteDeadline(time.Now().Add(opts.writeTimeout))
 			// 没有数据
 			if !ok {
-				client.Conn.WriteMessage(websocket.CloseMessage, nil)
+				client.Conn.WriteMessage(websocket.CloseMessage, client.closeMessage())
 				return
 			}
-			if err := fn(client, websocket.TextMessage, message); err != nil {
+			if err := fn(client, message); err != nil {
+				client.Manager.onError(client, "websocket写入数据失败", err)
 				return
 			}
 		case <-ticker.C: // 心跳监测
-			client.Conn.SetWriteDeadline(time.Now().Add(writeTimeOut))
+			client.Conn.SetWriteDeadline(time.Now().Add(opts.writeTimeout))
 			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				client.Manager.onError(client, "websocket发送心跳失败", err)
 				return
 			}
 		}
@@ -116,52 +207,80 @@ func (client *Client) write(fn func(client *Client, messageType int, message []b
 
 // defaultWrite 默认写入数据方法
 func (client *Client) defaultWrite() {
-	client.write(func(client *Client, messageType int, message []byte) error {
-		// 有数据
+	client.write(func(client *Client, message *Message) error {
+		// 二进制帧、消息信封等不可合并的消息直接单独写入，换行合并会破坏二进制数据及逐帧的JSON解析
+		if message.Type != websocket.TextMessage || !message.batch {
+			return client.Conn.WriteMessage(message.Type, message.Data)
+		}
 		// 获取写入文本消息的写入器,执行写入操作
 		writer, err := client.Conn.NextWriter(websocket.TextMessage)
 		if err != nil {
-			return nil
+			return err
 		}
 		// 写入消息
-		writer.Write(message)
+		writer.Write(message.Data)
+		// 将队列中连续的可合并文本消息合并到同一帧
 		n := len(client.Send)
 		for i := 0; i < n; i++ {
+			next, ok := <-client.Send
+			if !ok {
+				// Send已关闭，由write循环发送关闭帧
+				break
+			}
+			if next.Type != websocket.TextMessage || !next.batch {
+				// 先结束当前文本帧，再单独写入不可合并的消息，保证消息顺序
+				if err = writer.Close(); err != nil {
+					return err
+				}
+				return client.Conn.WriteMessage(next.Type, next.Data)
+			}
 			writer.Write(LINE)
-			writer.Write(<-client.Send)
-		}
-		if err := writer.Close(); err != nil {
-			return nil
+			writer.Write(next.Data)
 		}
-		return nil
+		return writer.Close()
 	})
 }
 
 // read 读取数据
 func (client *Client) read(fn func(client *Client, messageType int, message []byte, err error) error) {
+	var readErr error
 	defer func() {
-		client.Manager.UnRegister <- client
+		client.Manager.unregister(client)
 		client.Conn.Close()
+		client.cancel()
+		client.Manager.onDisconnect(client, client.disconnectReason(readErr))
 	}()
+	opts := &client.Manager.options
 	// 设置最大消息大小
-	client.Conn.SetReadLimit(maxMessageSize)
+	client.Conn.SetReadLimit(opts.maxMessageSize)
 	// 设置读取截止时间，防止超时
-	client.Conn.SetReadDeadline(time.Now().Add(readTimeOut))
+	client.Conn.SetReadDeadline(time.Now().Add(opts.readTimeout))
 	// 设置心跳消息处理器
 	client.Conn.SetPongHandler(func(string) error {
-		client.Conn.SetReadDeadline(time.Now().Add(readTimeOut))
+		client.Conn.SetReadDeadline(time.Now().Add(opts.readTimeout))
 		return nil
 	})
 	for {
 		messageType, message, err := client.Conn.ReadMessage()
 		if err != nil {
+			readErr = err
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				client.Manager.onError(client, "websocket连接异常关闭", err)
 			}
 			break
 		}
+		if !client.allowInbound(len(message)) {
+			if client.rejectInbound() {
+				readErr = errRateLimited
+				break
+			}
+			continue
+		}
+		client.Manager.onMessage(client, messageType, message)
 		err = fn(client, messageType, message, err)
 		if err != nil {
+			readErr = err
+			client.Manager.onError(client, "websocket处理消息失败", err)
 			break
 		}
 	}
@@ -171,8 +290,15 @@ func (client *Client) read(fn func(client *Client, messageType int, message []by
 func (client *Client) defaultRead() {
 	client.read(func(client *Client, messageType int, message []byte, err error) error {
 		// 接收到消息处理逻辑
+		if messageType == websocket.BinaryMessage {
+			client.Manager.Deliver(&Delivery{Target: TargetAll, MessageType: BinaryMessage, Data: message})
+			return nil
+		}
 		message = bytes.TrimSpace(bytes.Replace(message, LINE, SPACE, -1))
-		client.Manager.Broadcast <- message
+		select {
+		case client.Manager.Broadcast <- message:
+		case <-client.Manager.done:
+		}
 		return nil
 	})
 }
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"fmt"
+
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/gorilla/websocket"
+)
+
+// Hooks 客户端生命周期钩子，均在客户端自己的协程中调用，可以在其中调用Manager的方法
+type Hooks struct {
+	// OnConnect 连接建立并注册成功后调用
+	OnConnect func(client *Client)
+	// OnDisconnect 连接断开后调用，每个连接只调用一次
+	OnDisconnect func(client *Client, reason string)
+	// OnError 读写出错或读取处理方法返回错误时调用
+	OnError func(client *Client, err error)
+	// OnMessage 收到消息后、交给读取处理方法前调用
+	OnMessage func(client *Client, messageType int, message []byte)
+}
+
+// WithHooks 设置客户端生命周期钩子
+func WithHooks(hooks Hooks) ManagerOption {
+	return func(manager *Manager) {
+		manager.hooks = hooks
+	}
+}
+
+// WithLogger 设置日志，默认不打印日志
+func WithLogger(l loggerx.Logger) ManagerOption {
+	return func(manager *Manager) {
+		manager.l = l
+	}
+}
+
+func (manager *Manager) onConnect(client *Client) {
+	manager.l.Debug("websocket连接建立", client.logFields()...)
+	if manager.hooks.OnConnect != nil {
+		manager.hooks.OnConnect(client)
+	}
+}
+
+func (manager *Manager) onDisconnect(client *Client, reason string) {
+	manager.l.Debug("websocket连接断开", append(client.logFields(), loggerx.String("reason", reason))...)
+	if manager.hooks.OnDisconnect != nil {
+		manager.hooks.OnDisconnect(client, reason)
+	}
+}
+
+func (manager *Manager) onError(client *Client, msg string, err error) {
+	manager.l.Error(msg, append(client.logFields(), loggerx.Error(err))...)
+	if manager.hooks.OnError != nil {
+		manager.hooks.OnError(client, err)
+	}
+}
+
+func (manager *Manager) onMessage(client *Client, messageType int, message []byte) {
+	if manager.hooks.OnMessage != nil {
+		manager.hooks.OnMessage(client, messageType, message)
+	}
+}
+
+// logFields 客户端日志字段
+func (client *Client) logFields() []loggerx.Field {
+	return []loggerx.Field{
+		loggerx.String("client", client.ID),
+		loggerx.Int64("uid", client.UID),
+		loggerx.String("remote", client.RemoteAddr),
+		loggerx.String("transport", client.Transport),
+	}
+}
+
+// disconnectReason 根据服务端设置的关闭原因或读取错误生成断开原因
+func (client *Client) disconnectReason(err error) string {
+	if code, reason := client.closeInfo(); code != 0 {
+		return fmt.Sprintf("server closed(%d): %s", code, reason)
+	}
+	if ce, ok := err.(*websocket.CloseError); ok {
+		return fmt.Sprintf("client closed(%d): %s", ce.Code, ce.Text)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "closed"
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrManagerClosed 管理器已关闭
+var ErrManagerClosed = errors.New("websocket manager已关闭")
+
+// Stop 优雅关闭管理器：
+// 1. 拒绝新的连接注册；
+// 2. 向全部客户端发送CloseGoingAway关闭帧（携带reason）；
+// 3. 等待写协程将队列中的消息写完，超过ctx截止时间后强制关闭剩余连接；
+// 4. Run返回
+// 注：Stop需要Run正在运行，否则会一直阻塞到ctx结束
+func (manager *Manager) Stop(ctx context.Context, reason string) error {
+	if !manager.closing.CompareAndSwap(false, true) {
+		return ErrManagerClosed
+	}
+	select {
+	case manager.stop <- reason:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-manager.done
+
+	flushed := make(chan struct{})
+	go func() {
+		manager.writers.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		// 超时，强制关闭剩余连接
+		for _, client := range manager.drained {
+			client.closeTransport()
+		}
+		return ctx.Err()
+	}
+}
+
+// register 注册客户端，管理器已关闭时以CloseGoingAway关闭连接并返回ErrManagerClosed
+func (manager *Manager) register(client *Client) error {
+	// writers.Add需与Stop中的writers.Wait错开：Run在关闭done前持有写锁设置stopped，
+	// 因此这里的Add要么发生在done关闭之前，要么能看到stopped
+	manager.mu.RLock()
+	stopped := manager.stopped
+	if !stopped {
+		manager.writers.Add(1)
+	}
+	manager.mu.RUnlock()
+	if stopped {
+		return manager.rejectRegister(client)
+	}
+	select {
+	case manager.Register <- client:
+		return nil
+	case <-manager.done:
+		manager.writers.Done()
+		return manager.rejectRegister(client)
+	}
+}
+
+// rejectRegister 管理器已关闭，以CloseGoingAway关闭连接
+func (manager *Manager) rejectRegister(client *Client) error {
+	if client.Conn != nil {
+		client.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(manager.options.writeTimeout))
+	}
+	client.closeTransport()
+	return ErrManagerClosed
+}
+
+// unregister 注销客户端，管理器已关闭时直接返回
+func (manager *Manager) unregister(client *Client) {
+	select {
+	case manager.UnRegister <- client:
+	case <-manager.done:
+	}
+}
+
+// closeTransport 强制关闭底层连接
+func (client *Client) closeTransport() {
+	if client.Conn != nil {
+		client.Conn.Close()
+	}
+	client.cancel()
+}
+
+// closeWith 设置关闭帧的状态码与原因（需在关闭Send之前调用）
+func (client *Client) closeWith(code int, reason string) {
+	client.closeMu.Lock()
+	defer client.closeMu.Unlock()
+	client.closeCode = code
+	client.closeReason = reason
+}
+
+// closeInfo 返回关闭帧的状态码与原因
+func (client *Client) closeInfo() (int, string) {
+	client.closeMu.Lock()
+	defer client.closeMu.Unlock()
+	return client.closeCode, client.closeReason
+}
+
+// closeMessage 返回写协程退出前发送的关闭帧
+func (client *Client) closeMessage() []byte {
+	code, reason := client.closeInfo()
+	if code == 0 {
+		return nil
+	}
+	return websocket.FormatCloseMessage(code, reason)
+}
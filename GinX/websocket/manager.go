@@ -1,43 +1,461 @@
 package websocket
 
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/LEILEI0628/GinPro/middleware/limiter"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/gorilla/websocket"
+)
+
 // Manager ws客户端管理器
 type Manager struct {
 	Clients    map[*Client]struct{}
 	Broadcast  chan []byte
 	Register   chan *Client
 	UnRegister chan *Client
+
+	mu         sync.RWMutex                    // 保护Clients、rooms与users的并发读取（写入只发生在Run中）
+	rooms      map[string]map[*Client]struct{} // 房间 -> 成员
+	users      map[int64]map[*Client]struct{}  // 用户ID -> 该用户的全部连接（多标签页/多设备）
+	join       chan *roomOp
+	leave      chan *roomOp
+	deliveries chan *Delivery
+	clientSend chan *clientMessage
+
+	nodeID    string    // 当前实例ID，用于过滤总线上自己发出的消息
+	backplane Backplane // 跨实例消息总线，为nil时只投递到本实例
+
+	outbox     Outbox                          // 可靠消息发件箱，为nil时不支持可靠投递
+	reliableMu [reliableLockStripes]sync.Mutex // 按用户分段，保证同一用户的可靠消息按序号投递
+
+	presence    Presence                             // 在线状态追踪，为nil时不追踪
+	presenceOps chan func(ctx context.Context) error // 在线状态更新队列，避免阻塞事件循环
+
+	options  options
+	upgrader *websocket.Upgrader
+	hooks    Hooks
+	l        loggerx.Logger
+
+	limitMu        sync.Mutex
+	userLimiters   map[int64]*inboundLimiter // 用户级上行限流器
+	inboundLimiter limiter.Limiter           // 外部限流器，为nil时不使用
+	inboundPrefix  string
+
+	closing atomic.Bool    // 是否已开始关闭，关闭后拒绝新的注册
+	stopped bool           // Run是否已退出（受mu保护），之后不再登记写协程
+	stop    chan string    // 关闭信号，携带关闭原因
+	done    chan struct{}  // Run退出后关闭
+	writers sync.WaitGroup // 仍在运行的写协程
+	drained []*Client      // 关闭时被断开的客户端，用于超时后强制关闭连接
+}
+
+// TargetKind 消息投递目标类型
+type TargetKind string
+
+const (
+	TargetAll            TargetKind = "all"             // 全部客户端
+	TargetRoom           TargetKind = "room"            // 房间内的客户端
+	TargetUser           TargetKind = "user"            // 用户的全部连接
+	TargetDisconnectUser TargetKind = "disconnect_user" // 断开用户的全部连接
+)
+
+// Delivery 一次消息投递，同时也是跨实例总线上传输的消息
+type Delivery struct {
+	NodeID      string     `json:"node_id"`
+	Target      TargetKind `json:"target"`
+	Room        string     `json:"room,omitempty"`
+	UID         int64      `json:"uid,omitempty"`
+	MessageType int        `json:"message_type,omitempty"` // 帧类型，为0时按TextMessage发送
+	Seq         uint64     `json:"seq,omitempty"`          // 可靠消息的序号
+	Data        []byte     `json:"data,omitempty"`
+}
+
+// message 转换为出站消息
+func (d *Delivery) message() *Message {
+	if d.MessageType == 0 {
+		return &Message{Type: TextMessage, Data: d.Data, Seq: d.Seq}
+	}
+	return &Message{Type: d.MessageType, Data: d.Data, Seq: d.Seq}
+}
+
+// roomOp 加入/离开房间操作
+type roomOp struct {
+	client *Client
+	room   string
+}
+
+// clientMessage 发往单个客户端的消息
+type clientMessage struct {
+	client  *Client
+	message *Message
 }
 
 // NewWsManager 创建ws客户端管理器
-func NewWsManager() *Manager {
-	return &Manager{
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		UnRegister: make(chan *Client),
-		Clients:    make(map[*Client]struct{}),
+func NewWsManager(opts ...ManagerOption) *Manager {
+	manager := &Manager{
+		Broadcast:    make(chan []byte),
+		Register:     make(chan *Client),
+		UnRegister:   make(chan *Client),
+		Clients:      make(map[*Client]struct{}),
+		rooms:        make(map[string]map[*Client]struct{}),
+		users:        make(map[int64]map[*Client]struct{}),
+		join:         make(chan *roomOp),
+		leave:        make(chan *roomOp),
+		deliveries:   make(chan *Delivery),
+		clientSend:   make(chan *clientMessage),
+		stop:         make(chan string),
+		done:         make(chan struct{}),
+		nodeID:       defaultNodeID(),
+		options:      defaultOptions(),
+		l:            &loggerx.NoneLogger{},
+		userLimiters: make(map[int64]*inboundLimiter),
+	}
+	for _, opt := range opts {
+		opt(manager)
+	}
+	manager.upgrader = manager.options.upgrader()
+	return manager
+}
+
+// NodeID 返回当前实例ID
+func (manager *Manager) NodeID() string {
+	return manager.nodeID
+}
+
+// Join 将客户端加入房间
+func (manager *Manager) Join(client *Client, room string) {
+	select {
+	case manager.join <- &roomOp{client: client, room: room}:
+	case <-manager.done:
+	}
+}
+
+// Leave 将客户端移出房间
+func (manager *Manager) Leave(client *Client, room string) {
+	select {
+	case manager.leave <- &roomOp{client: client, room: room}:
+	case <-manager.done:
+	}
+}
+
+// BroadcastToRoom 向房间内全部客户端广播消息
+func (manager *Manager) BroadcastToRoom(room string, message []byte) {
+	manager.Deliver(&Delivery{Target: TargetRoom, Room: room, Data: message})
+}
+
+// SendToUser 向用户的全部连接发送消息
+func (manager *Manager) SendToUser(uid int64, message []byte) {
+	manager.Deliver(&Delivery{Target: TargetUser, UID: uid, Data: message})
+}
+
+// DisconnectUser 断开用户的全部连接（包括其他实例上的连接）
+// WebSocket客户端收到CloseNormalClosure关闭帧，SSE客户端收到close事件，原因均为"disconnected by server"
+func (manager *Manager) DisconnectUser(uid int64) {
+	manager.Deliver(&Delivery{Target: TargetDisconnectUser, UID: uid})
+}
+
+// Deliver 投递到本实例，并通过消息总线投递到其他实例
+func (manager *Manager) Deliver(d *Delivery) {
+	d.NodeID = manager.nodeID
+	manager.DeliverLocal(d)
+	manager.publish(d)
+}
+
+// DeliverLocal 只投递到本实例的客户端
+func (manager *Manager) DeliverLocal(d *Delivery) {
+	select {
+	case manager.deliveries <- d:
+	case <-manager.done:
+	}
+}
+
+// SendToClient 向单个客户端发送消息，客户端已注销时忽略
+// 注：不要直接写入client.Send，Send只能由Manager写入和关闭
+func (manager *Manager) SendToClient(client *Client, message *Message) {
+	select {
+	case manager.clientSend <- &clientMessage{client: client, message: message}:
+	case <-manager.done:
+	}
+}
+
+// ClientCount 返回本实例已注册的客户端数量
+func (manager *Manager) ClientCount() int {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return len(manager.Clients)
+}
+
+// RoomMembers 返回房间内的全部客户端
+func (manager *Manager) RoomMembers(room string) []*Client {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	members := make([]*Client, 0, len(manager.rooms[room]))
+	for client := range manager.rooms[room] {
+		members = append(members, client)
+	}
+	return members
+}
+
+// ClientRooms 返回客户端加入的全部房间
+func (manager *Manager) ClientRooms(client *Client) []string {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	rooms := make([]string, 0, len(client.rooms))
+	for room := range client.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// UserClients 返回用户在本实例的全部连接
+func (manager *Manager) UserClients(uid int64) []*Client {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	clients := make([]*Client, 0, len(manager.users[uid]))
+	for client := range manager.users[uid] {
+		clients = append(clients, client)
 	}
+	return clients
 }
 
-// Run 启动ws客户端管理器
+// Run 启动ws客户端管理器，调用Stop后返回
 func (manager *Manager) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if manager.backplane != nil {
+		go manager.subscribe(ctx)
+	}
+	if manager.presence != nil {
+		go manager.runPresence()
+		go manager.heartbeat(ctx)
+	}
 	for {
 		select {
+		case reason := <-manager.stop:
+			manager.mu.Lock()
+			for client := range manager.Clients {
+				client.closeWith(websocket.CloseGoingAway, reason)
+				manager.drained = append(manager.drained, client)
+				manager.remove(client)
+			}
+			manager.stopped = true
+			manager.mu.Unlock()
+			if manager.presence != nil {
+				// 处理完剩余的下线操作后退出
+				close(manager.presenceOps)
+			}
+			close(manager.done)
+			return
 		case client := <-manager.Register:
-			manager.Clients[client] = struct{}{}
+			manager.mu.Lock()
+			manager.add(client)
+			manager.mu.Unlock()
 		case client := <-manager.UnRegister:
-			if _, ok := manager.Clients[client]; ok {
-				delete(manager.Clients, client)
-				close(client.Send)
-			}
+			manager.mu.Lock()
+			manager.remove(client)
+			manager.mu.Unlock()
+		case op := <-manager.join:
+			manager.mu.Lock()
+			manager.joinRoom(op.client, op.room)
+			manager.mu.Unlock()
+		case op := <-manager.leave:
+			manager.mu.Lock()
+			manager.leaveRoom(op.client, op.room)
+			manager.mu.Unlock()
 		case message := <-manager.Broadcast:
+			manager.mu.Lock()
 			for client := range manager.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(manager.Clients, client)
-				}
+				// defaultRead已将换行替换为空格，写入时可以按换行合并
+				manager.send(client, &Message{Type: TextMessage, Data: message, batch: true})
+			}
+			manager.mu.Unlock()
+			// 不阻塞事件循环
+			go manager.publish(&Delivery{NodeID: manager.nodeID, Target: TargetAll, Data: message})
+		case msg := <-manager.clientSend:
+			manager.mu.Lock()
+			if _, ok := manager.Clients[msg.client]; ok {
+				manager.send(msg.client, msg.message)
 			}
+			manager.mu.Unlock()
+		case d := <-manager.deliveries:
+			manager.mu.Lock()
+			manager.dispatch(d)
+			manager.mu.Unlock()
+		}
+	}
+}
+
+// dispatch 将投递分发到本实例的客户端（调用方需持有写锁）
+func (manager *Manager) dispatch(d *Delivery) {
+	message := d.message()
+	switch d.Target {
+	case TargetAll:
+		for client := range manager.Clients {
+			manager.send(client, message)
+		}
+	case TargetRoom:
+		for client := range manager.rooms[d.Room] {
+			manager.send(client, message)
+		}
+	case TargetUser:
+		for client := range manager.users[d.UID] {
+			manager.send(client, message)
+		}
+	case TargetDisconnectUser:
+		for client := range manager.users[d.UID] {
+			// 服务端主动断开属于正常关闭，避免客户端按异常断线立即重连
+			client.closeWith(websocket.CloseNormalClosure, "disconnected by server")
+			manager.remove(client)
 		}
 	}
 }
+
+// publish 将投递发布到消息总线
+func (manager *Manager) publish(d *Delivery) {
+	if manager.backplane == nil {
+		return
+	}
+	if err := manager.backplane.Publish(context.Background(), d); err != nil {
+		manager.l.Error("websocket消息总线发布失败", loggerx.Error(err),
+			loggerx.String("target", string(d.Target)))
+	}
+}
+
+// subscribe 订阅消息总线，将其他实例发出的投递分发到本实例
+func (manager *Manager) subscribe(ctx context.Context) {
+	err := manager.backplane.Subscribe(ctx, func(d *Delivery) {
+		// 忽略自己发出的消息，避免重复投递
+		if d.NodeID == manager.nodeID {
+			return
+		}
+		manager.DeliverLocal(d)
+	})
+	if err != nil && ctx.Err() == nil {
+		manager.l.Error("websocket消息总线订阅失败", loggerx.Error(err))
+	}
+}
+
+// add 注册客户端并建立用户索引（调用方需持有写锁）
+func (manager *Manager) add(client *Client) {
+	manager.Clients[client] = struct{}{}
+	if client.UID == 0 {
+		return
+	}
+	clients, ok := manager.users[client.UID]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		manager.users[client.UID] = clients
+		// 用户在本实例的第一个连接
+		uid := client.UID
+		manager.updatePresence(func(ctx context.Context) error {
+			return manager.presence.Connect(ctx, manager.nodeID, uid)
+		})
+	}
+	clients[client] = struct{}{}
+}
+
+// remove 移除客户端并清理其加入的房间与用户索引（调用方需持有写锁）
+// 关闭Send后写协程会发送关闭帧并断开连接
+func (manager *Manager) remove(client *Client) {
+	if _, ok := manager.Clients[client]; !ok {
+		return
+	}
+	for room := range client.rooms {
+		manager.leaveRoom(client, room)
+	}
+	if clients, ok := manager.users[client.UID]; ok {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(manager.users, client.UID)
+			manager.removeUserLimiter(client.UID)
+			// 用户在本实例的最后一个连接
+			uid := client.UID
+			manager.updatePresence(func(ctx context.Context) error {
+				return manager.presence.Disconnect(ctx, manager.nodeID, uid)
+			})
+		}
+	}
+	delete(manager.Clients, client)
+	close(client.Send)
+}
+
+// joinRoom 加入房间（调用方需持有写锁）
+func (manager *Manager) joinRoom(client *Client, room string) {
+	// 已注销的客户端不再加入房间
+	if _, ok := manager.Clients[client]; !ok {
+		return
+	}
+	members, ok := manager.rooms[room]
+	if !ok {
+		members = make(map[*Client]struct{})
+		manager.rooms[room] = members
+	}
+	if _, ok = members[client]; ok {
+		return
+	}
+	if client.UID != 0 && !manager.userInRoom(client.UID, room, client) {
+		// 用户在本实例第一次加入该房间
+		uid := client.UID
+		manager.updatePresence(func(ctx context.Context) error {
+			return manager.presence.JoinRoom(ctx, manager.nodeID, uid, room)
+		})
+	}
+	members[client] = struct{}{}
+	client.rooms[room] = struct{}{}
+}
+
+// leaveRoom 离开房间，房间为空时删除（调用方需持有写锁）
+func (manager *Manager) leaveRoom(client *Client, room string) {
+	members, ok := manager.rooms[room]
+	if !ok {
+		return
+	}
+	if _, ok = members[client]; !ok {
+		return
+	}
+	delete(members, client)
+	delete(client.rooms, room)
+	if len(members) == 0 {
+		delete(manager.rooms, room)
+	}
+	if client.UID != 0 && !manager.userInRoom(client.UID, room, client) {
+		// 用户在本实例的全部连接都已离开该房间
+		uid := client.UID
+		manager.updatePresence(func(ctx context.Context) error {
+			return manager.presence.LeaveRoom(ctx, manager.nodeID, uid, room)
+		})
+	}
+}
+
+// userInRoom 用户除except外是否还有其他连接在房间内（调用方需持有锁）
+func (manager *Manager) userInRoom(uid int64, room string, except *Client) bool {
+	for client := range manager.users[uid] {
+		if client == except {
+			continue
+		}
+		if _, ok := client.rooms[room]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultNodeID 生成默认实例ID
+func defaultNodeID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), newID())
+}
+
+// newID 生成随机ID
+func newID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
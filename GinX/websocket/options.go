@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultWriteTimeout 数据写入时间限制
+	defaultWriteTimeout = 10 * time.Second
+	// defaultReadTimeout 数据读取时间限制
+	defaultReadTimeout = 60 * time.Second
+	// defaultMaxMessageSize 最大消息大小(字节)
+	defaultMaxMessageSize = 512
+	// defaultBufferSize 读写缓冲区大小(字节)
+	defaultBufferSize = 1024
+	// defaultSendBufferSize 发送队列容量(条)
+	defaultSendBufferSize = 256
+)
+
+// options 服务端配置
+type options struct {
+	writeTimeout      time.Duration // 数据写入时间限制
+	readTimeout       time.Duration // 数据读取时间限制，心跳间隔为其9/10
+	maxMessageSize    int64         // 最大消息大小(字节)
+	readBufferSize    int           // 读缓冲区大小(字节)
+	writeBufferSize   int           // 写缓冲区大小(字节)
+	sendBufferSize    int           // 发送队列容量(条)
+	allowedOrigins    []string      // 允许的Origin，为空时只允许同源，"*"允许任意来源
+	subprotocols      []string      // 支持的子协议
+	enableCompression bool          // 是否启用permessage-deflate压缩
+
+	backpressure     BackpressurePolicy // 慢消费者处理策略
+	blockTimeout     time.Duration      // PolicyBlock下的最长阻塞时间
+	slowConsumerHook SlowConsumerHook   // 慢消费者钩子
+
+	rpcTimeout time.Duration // 调用的默认超时时间
+
+	connRateLimit   RateLimit       // 连接级上行限流
+	userRateLimit   RateLimit       // 用户级上行限流
+	rateLimitAction RateLimitAction // 超过限流阈值时的处理方式
+}
+
+func defaultOptions() options {
+	return options{
+		writeTimeout:    defaultWriteTimeout,
+		readTimeout:     defaultReadTimeout,
+		maxMessageSize:  defaultMaxMessageSize,
+		readBufferSize:  defaultBufferSize,
+		writeBufferSize: defaultBufferSize,
+		sendBufferSize:  defaultSendBufferSize,
+		rpcTimeout:      defaultRPCTimeout,
+	}
+}
+
+// heartBeatTime 心跳检测时间
+func (o *options) heartBeatTime() time.Duration {
+	heartBeat := (o.readTimeout * 9) / 10
+	if heartBeat <= 0 {
+		// 极小的readTimeout取9/10后为0，time.NewTicker不接受非正数
+		return o.readTimeout
+	}
+	return heartBeat
+}
+
+// upgrader 根据配置创建websocket升级器
+func (o *options) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:    o.readBufferSize,
+		WriteBufferSize:   o.writeBufferSize,
+		Subprotocols:      o.subprotocols,
+		EnableCompression: o.enableCompression,
+		CheckOrigin:       o.checkOrigin,
+	}
+}
+
+// checkOrigin 校验请求来源，防止跨站WebSocket劫持
+func (o *options) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// 非浏览器客户端不携带Origin
+		return true
+	}
+	if len(o.allowedOrigins) == 0 {
+		// 默认只允许同源
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range o.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagerOption 管理器配置选项
+type ManagerOption func(*Manager)
+
+// WithBackplane 设置跨实例消息总线，广播、房间消息及用户消息会投递到集群内全部实例
+func WithBackplane(backplane Backplane) ManagerOption {
+	return func(manager *Manager) {
+		manager.backplane = backplane
+	}
+}
+
+// WithNodeID 设置当前实例ID（默认由主机名、进程号及随机数生成）
+func WithNodeID(nodeID string) ManagerOption {
+	return func(manager *Manager) {
+		manager.nodeID = nodeID
+	}
+}
+
+// WithWriteTimeout 设置数据写入时间限制（默认10s），非正数时忽略
+func WithWriteTimeout(d time.Duration) ManagerOption {
+	return func(manager *Manager) {
+		if d > 0 {
+			manager.options.writeTimeout = d
+		}
+	}
+}
+
+// WithReadTimeout 设置数据读取时间限制（默认60s），心跳间隔为其9/10，非正数时忽略
+func WithReadTimeout(d time.Duration) ManagerOption {
+	return func(manager *Manager) {
+		if d > 0 {
+			manager.options.readTimeout = d
+		}
+	}
+}
+
+// WithMaxMessageSize 设置最大消息大小（默认512字节），非正数时忽略
+func WithMaxMessageSize(size int64) ManagerOption {
+	return func(manager *Manager) {
+		if size > 0 {
+			manager.options.maxMessageSize = size
+		}
+	}
+}
+
+// WithBufferSize 设置读写缓冲区大小（默认均为1KB），非正数时忽略
+func WithBufferSize(readSize, writeSize int) ManagerOption {
+	return func(manager *Manager) {
+		if readSize > 0 {
+			manager.options.readBufferSize = readSize
+		}
+		if writeSize > 0 {
+			manager.options.writeBufferSize = writeSize
+		}
+	}
+}
+
+// WithSendBufferSize 设置每个客户端的发送队列容量（默认256条），非正数时忽略
+func WithSendBufferSize(size int) ManagerOption {
+	return func(manager *Manager) {
+		if size > 0 {
+			manager.options.sendBufferSize = size
+		}
+	}
+}
+
+// WithAllowedOrigins 设置允许的Origin白名单，如"https://example.com"，"*"表示允许任意来源
+// 未设置时只允许同源请求
+func WithAllowedOrigins(origins ...string) ManagerOption {
+	return func(manager *Manager) {
+		manager.options.allowedOrigins = origins
+	}
+}
+
+// WithSubprotocols 设置服务端支持的子协议（按优先级排列）
+func WithSubprotocols(protocols ...string) ManagerOption {
+	return func(manager *Manager) {
+		manager.options.subprotocols = protocols
+	}
+}
+
+// WithCompression 设置是否启用permessage-deflate压缩
+func WithCompression(enable bool) ManagerOption {
+	return func(manager *Manager) {
+		manager.options.enableCompression = enable
+	}
+}
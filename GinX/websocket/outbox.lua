@@ -0,0 +1,14 @@
+-- 发件箱追加：分配序号与写入Stream在同一脚本中原子完成，保证Stream中的消息ID按序号递增
+-- KEYS[1] 序号计数器（不设置过期时间，否则用户空闲后序号会从1重新开始）
+-- KEYS[2] 发件箱Stream
+local seq_key = KEYS[1]
+local stream_key = KEYS[2]
+-- 未包含序号的消息信封，读取时再写入序号
+local data = ARGV[1]
+local capacity = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+local seq = redis.call('INCR', seq_key)
+redis.call('XADD', stream_key, 'MAXLEN', capacity, string.format('%d-0', seq), 'data', data)
+redis.call('PEXPIRE', stream_key, ttl)
+return seq
@@ -0,0 +1,363 @@
+package websocket
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	ginx "github.com/LEILEI0628/GinPro/GinX"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed presence.lua
+var presenceLua string
+
+// Presence 在线状态追踪，Manager在用户上线/下线、加入/离开房间时调用，并定期发送心跳
+type Presence interface {
+	// Connect 用户在当前实例的第一个连接建立
+	Connect(ctx context.Context, nodeID string, uid int64) error
+	// Disconnect 用户在当前实例的最后一个连接断开
+	Disconnect(ctx context.Context, nodeID string, uid int64) error
+	// JoinRoom 用户在当前实例第一次加入房间
+	JoinRoom(ctx context.Context, nodeID string, uid int64, room string) error
+	// LeaveRoom 用户在当前实例的全部连接都已离开房间
+	LeaveRoom(ctx context.Context, nodeID string, uid int64, room string) error
+	// Heartbeat 上报当前实例的全部在线用户及其所在房间
+	Heartbeat(ctx context.Context, nodeID string, users map[int64][]string) error
+	// Interval 心跳间隔
+	Interval() time.Duration
+}
+
+// presenceQueueSize 在线状态更新队列容量
+const presenceQueueSize = 1024
+
+// WithPresence 设置在线状态追踪
+func WithPresence(presence Presence) ManagerOption {
+	return func(manager *Manager) {
+		manager.presence = presence
+		manager.presenceOps = make(chan func(ctx context.Context) error, presenceQueueSize)
+	}
+}
+
+// updatePresence 将在线状态更新放入队列，由runPresence按顺序执行（在事件循环中调用）
+func (manager *Manager) updatePresence(op func(ctx context.Context) error) {
+	if manager.presence == nil {
+		return
+	}
+	select {
+	case manager.presenceOps <- op:
+	default:
+		// 队列已满，丢弃本次更新，依赖下一次心跳修正
+		manager.l.Warn("websocket在线状态更新队列已满，丢弃本次更新")
+	}
+}
+
+// runPresence 按顺序执行在线状态更新，队列关闭后退出
+func (manager *Manager) runPresence() {
+	for op := range manager.presenceOps {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.presence.Interval())
+		if err := op(ctx); err != nil {
+			manager.l.Error("websocket更新在线状态失败", loggerx.Error(err))
+		}
+		cancel()
+	}
+}
+
+// heartbeat 定期上报本实例的在线用户及其所在房间
+func (manager *Manager) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(manager.presence.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		manager.mu.RLock()
+		users := make(map[int64][]string, len(manager.users))
+		for uid, clients := range manager.users {
+			rooms := make(map[string]struct{})
+			for client := range clients {
+				for room := range client.rooms {
+					rooms[room] = struct{}{}
+				}
+			}
+			list := make([]string, 0, len(rooms))
+			for room := range rooms {
+				list = append(list, room)
+			}
+			users[uid] = list
+		}
+		manager.mu.RUnlock()
+		hctx, cancel := context.WithTimeout(ctx, manager.presence.Interval())
+		if err := manager.presence.Heartbeat(hctx, manager.nodeID, users); err != nil {
+			manager.l.Error("websocket在线状态心跳失败", loggerx.Error(err))
+		}
+		cancel()
+	}
+}
+
+// PresenceEventType 在线状态事件类型
+type PresenceEventType string
+
+const (
+	PresenceOnline    PresenceEventType = "online"     // 用户上线（集群内第一个连接）
+	PresenceOffline   PresenceEventType = "offline"    // 用户下线（集群内最后一个连接断开或心跳超时）
+	PresenceJoinRoom  PresenceEventType = "join_room"  // 用户加入房间
+	PresenceLeaveRoom PresenceEventType = "leave_room" // 用户离开房间
+)
+
+// PresenceEvent 在线状态事件
+type PresenceEvent struct {
+	Type   PresenceEventType `json:"type"`
+	UID    int64             `json:"uid"`
+	Room   string            `json:"room,omitempty"`
+	NodeID string            `json:"node_id"`
+	At     int64             `json:"at"` // 毫秒时间戳
+}
+
+// RedisPresence 基于Redis的在线状态追踪
+// 各实例定期上报心跳，超过3个心跳间隔未上报的记录视为过期，
+// 因此实例崩溃后其用户会在过期后自动下线，跨实例统计的在线人数保持准确
+type RedisPresence struct {
+	l        loggerx.Logger
+	client   redis.UniversalClient
+	prefix   string
+	interval time.Duration // 心跳间隔
+	ttl      time.Duration // 记录过期时间
+}
+
+// defaultPresenceInterval 默认心跳间隔
+const defaultPresenceInterval = 10 * time.Second
+
+// NewRedisPresence 创建基于Redis的在线状态追踪
+// interval: 心跳间隔，记录在3个心跳间隔内未刷新即过期，非正数时使用默认值10s
+func NewRedisPresence(l loggerx.Logger, client redis.UniversalClient, prefix string, interval time.Duration) *RedisPresence {
+	if interval <= 0 {
+		interval = defaultPresenceInterval
+	}
+	return &RedisPresence{
+		l:        l,
+		client:   client,
+		prefix:   prefix,
+		interval: interval,
+		ttl:      3 * interval,
+	}
+}
+
+func (p *RedisPresence) Interval() time.Duration {
+	return p.interval
+}
+
+func (p *RedisPresence) Connect(ctx context.Context, nodeID string, uid int64) error {
+	online, err := p.eval(ctx, "connect", nodeID, uid)
+	if err != nil || !online {
+		return err
+	}
+	return p.publish(ctx, &PresenceEvent{Type: PresenceOnline, UID: uid, NodeID: nodeID})
+}
+
+func (p *RedisPresence) Disconnect(ctx context.Context, nodeID string, uid int64) error {
+	offline, err := p.eval(ctx, "disconnect", nodeID, uid)
+	if err != nil || !offline {
+		return err
+	}
+	return p.publish(ctx, &PresenceEvent{Type: PresenceOffline, UID: uid, NodeID: nodeID})
+}
+
+// JoinRoom 用户加入房间，仅在集群内第一次加入时发布事件
+func (p *RedisPresence) JoinRoom(ctx context.Context, nodeID string, uid int64, room string) error {
+	joined, err := p.evalKeys(ctx, "join", p.roomKey(room), p.roomUserKey(room, uid), nodeID, uid)
+	if err != nil || !joined {
+		return err
+	}
+	return p.publish(ctx, &PresenceEvent{Type: PresenceJoinRoom, UID: uid, Room: room, NodeID: nodeID})
+}
+
+// LeaveRoom 用户离开房间，在其他实例上仍在该房间中时保留成员记录且不发布事件
+func (p *RedisPresence) LeaveRoom(ctx context.Context, nodeID string, uid int64, room string) error {
+	left, err := p.evalKeys(ctx, "leave", p.roomKey(room), p.roomUserKey(room, uid), nodeID, uid)
+	if err != nil || !left {
+		return err
+	}
+	return p.publish(ctx, &PresenceEvent{Type: PresenceLeaveRoom, UID: uid, Room: room, NodeID: nodeID})
+}
+
+func (p *RedisPresence) Heartbeat(ctx context.Context, nodeID string, users map[int64][]string) error {
+	now := time.Now().UnixMilli()
+	if len(users) > 0 {
+		pipe := p.client.Pipeline()
+		for uid, rooms := range users {
+			userKey := p.userKey(uid)
+			pipe.HSet(ctx, userKey, nodeID, now)
+			pipe.PExpire(ctx, userKey, p.ttl)
+			pipe.ZAdd(ctx, p.usersKey(), redis.Z{Score: float64(now), Member: uid})
+			pipe.HSet(ctx, p.lastSeenKey(), strconv.FormatInt(uid, 10), now)
+			for _, room := range rooms {
+				roomUserKey := p.roomUserKey(room, uid)
+				pipe.HSet(ctx, roomUserKey, nodeID, now)
+				pipe.PExpire(ctx, roomUserKey, p.ttl)
+				pipe.ZAdd(ctx, p.roomKey(room), redis.Z{Score: float64(now), Member: uid})
+				pipe.PExpire(ctx, p.roomKey(room), p.ttl)
+			}
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return p.reap(ctx, nodeID)
+}
+
+// reap 移除心跳超时的用户（如所在实例已崩溃）并发布下线事件
+func (p *RedisPresence) reap(ctx context.Context, nodeID string) error {
+	stale, err := p.client.Eval(ctx, presenceLua,
+		[]string{p.usersKey(), p.userKey(0), p.lastSeenKey()},
+		"reap", nodeID, 0, time.Now().UnixMilli(), p.ttl.Milliseconds()).StringSlice()
+	if err != nil {
+		return err
+	}
+	for _, s := range stale {
+		uid, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			continue
+		}
+		if err = p.publish(ctx, &PresenceEvent{Type: PresenceOffline, UID: uid, NodeID: nodeID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsOnline 用户是否在线
+func (p *RedisPresence) IsOnline(ctx context.Context, uid int64) (bool, error) {
+	score, err := p.client.ZScore(ctx, p.usersKey(), strconv.FormatInt(uid, 10)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return int64(score) >= p.minAlive(), nil
+}
+
+// OnlineCount 集群内在线用户数
+func (p *RedisPresence) OnlineCount(ctx context.Context) (int64, error) {
+	return p.client.ZCount(ctx, p.usersKey(), strconv.FormatInt(p.minAlive(), 10), "+inf").Result()
+}
+
+// OnlineUsers 集群内全部在线用户
+func (p *RedisPresence) OnlineUsers(ctx context.Context) ([]int64, error) {
+	return p.members(ctx, p.usersKey())
+}
+
+// RoomCount 房间内的在线用户数（如"N人正在浏览"）
+func (p *RedisPresence) RoomCount(ctx context.Context, room string) (int64, error) {
+	return p.client.ZCount(ctx, p.roomKey(room), strconv.FormatInt(p.minAlive(), 10), "+inf").Result()
+}
+
+// RoomUsers 房间内的全部在线用户
+func (p *RedisPresence) RoomUsers(ctx context.Context, room string) ([]int64, error) {
+	return p.members(ctx, p.roomKey(room))
+}
+
+// LastSeen 用户最近在线时间，从未上线时返回零值
+func (p *RedisPresence) LastSeen(ctx context.Context, uid int64) (time.Time, error) {
+	ms, err := p.client.HGet(ctx, p.lastSeenKey(), strconv.FormatInt(uid, 10)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// Subscribe 订阅集群内的在线状态事件，阻塞直到ctx结束，订阅断开时自动重连
+func (p *RedisPresence) Subscribe(ctx context.Context, handler func(event *PresenceEvent)) error {
+	return subscribeRedis(ctx, p.l, p.client, p.eventsKey(), func(payload string) {
+		var event PresenceEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			p.l.Error("websocket在线状态事件反序列化失败", loggerx.Error(err))
+			return
+		}
+		handler(&event)
+	})
+}
+
+func (p *RedisPresence) eval(ctx context.Context, op string, nodeID string, uid int64) (bool, error) {
+	return p.evalKeys(ctx, op, p.usersKey(), p.userKey(uid), nodeID, uid)
+}
+
+// evalKeys membersKey为成员ZSET，nodesKey为该用户所在实例HASH
+func (p *RedisPresence) evalKeys(ctx context.Context, op string, membersKey, nodesKey string, nodeID string, uid int64) (bool, error) {
+	res, err := p.client.Eval(ctx, presenceLua,
+		[]string{membersKey, nodesKey, p.lastSeenKey()},
+		op, nodeID, uid, time.Now().UnixMilli(), p.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func (p *RedisPresence) publish(ctx context.Context, event *PresenceEvent) error {
+	event.At = time.Now().UnixMilli()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.client.Publish(ctx, p.eventsKey(), data).Err()
+}
+
+// members 返回ZSET中未过期的全部用户
+func (p *RedisPresence) members(ctx context.Context, key string) ([]int64, error) {
+	vals, err := p.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: strconv.FormatInt(p.minAlive(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]int64, 0, len(vals))
+	for _, val := range vals {
+		uid, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			continue
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// minAlive 未过期记录的最早心跳时间
+func (p *RedisPresence) minAlive() int64 {
+	return time.Now().Add(-p.ttl).UnixMilli()
+}
+
+func (p *RedisPresence) usersKey() string {
+	return ginx.ClusterKey(p.prefix, "users")
+}
+
+func (p *RedisPresence) userKey(uid int64) string {
+	return ginx.ClusterKey(p.prefix, "user", uid)
+}
+
+func (p *RedisPresence) lastSeenKey() string {
+	return ginx.ClusterKey(p.prefix, "last_seen")
+}
+
+func (p *RedisPresence) roomKey(room string) string {
+	return ginx.ClusterKey(p.prefix, "room", room)
+}
+
+// roomUserKey 用户在房间中所在的实例，uid放在最后以免与房间名混淆
+func (p *RedisPresence) roomUserKey(room string, uid int64) string {
+	return ginx.ClusterKey(p.prefix, "room_user", room, uid)
+}
+
+func (p *RedisPresence) eventsKey() string {
+	return ginx.ClusterKey(p.prefix, "events")
+}
@@ -0,0 +1,66 @@
+-- 在线状态维护：用户在线与房间成员使用相同的结构，按实例分别记录，全部实例都离开后才移除
+-- KEYS[1] 成员ZSET（在线用户或房间成员，member为uid，score为最近心跳时间）
+-- KEYS[2] 该用户所在实例HASH（field为实例ID，value为最近心跳时间）
+-- KEYS[3] 最近在线时间HASH（field为uid）
+local members_key = KEYS[1]
+local nodes_key = KEYS[2]
+local last_seen_key = KEYS[3]
+-- 操作类型：connect / disconnect（用户在线）、join / leave（房间成员）、reap
+local op = ARGV[1]
+local node = ARGV[2]
+local uid = ARGV[3]
+local now = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local min = now - ttl
+
+-- 清理已过期实例（如崩溃的实例）留下的记录，返回剩余实例数
+local function prune()
+    local fields = redis.call('HGETALL', nodes_key)
+    local remain = 0
+    for i = 1, #fields, 2 do
+        if tonumber(fields[i + 1]) < min then
+            redis.call('HDEL', nodes_key, fields[i])
+        else
+            remain = remain + 1
+        end
+    end
+    return remain
+end
+
+if op == 'connect' or op == 'join' then
+    local present = prune() > 0
+    redis.call('HSET', nodes_key, node, now)
+    redis.call('PEXPIRE', nodes_key, ttl)
+    redis.call('ZADD', members_key, now, uid)
+    if op == 'connect' then
+        redis.call('HSET', last_seen_key, uid, now)
+    else
+        redis.call('PEXPIRE', members_key, ttl)
+    end
+    if present then
+        return 0
+    end
+    -- 用户从离线变为在线 / 第一次加入房间
+    return 1
+end
+
+if op == 'disconnect' or op == 'leave' then
+    redis.call('HDEL', nodes_key, node)
+    if op == 'disconnect' then
+        redis.call('HSET', last_seen_key, uid, now)
+    end
+    if prune() > 0 then
+        return 0
+    end
+    -- 用户在全部实例上都已离线 / 离开房间
+    redis.call('ZREM', members_key, uid)
+    redis.call('DEL', nodes_key)
+    return 1
+end
+
+-- reap：移除心跳超时的用户，返回被移除的uid列表
+local stale = redis.call('ZRANGEBYSCORE', members_key, '-inf', '(' .. min)
+if #stale > 0 then
+    redis.call('ZREMRANGEBYSCORE', members_key, '-inf', '(' .. min)
+end
+return stale
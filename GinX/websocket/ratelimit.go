@@ -0,0 +1,213 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/LEILEI0628/GinPro/middleware/limiter"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// ErrCodeRateLimited 上行消息超过限流阈值时警告帧的错误码
+	ErrCodeRateLimited = "rate_limited"
+	// rateLimitWarnInterval RateLimitDrop下每个连接发送警告帧的最小间隔（与限流速率的统计窗口一致）
+	rateLimitWarnInterval = time.Second
+)
+
+// errRateLimited 因超过限流阈值断开连接
+var errRateLimited = errors.New("websocket上行消息超过限流阈值")
+
+// RateLimitAction 上行消息超过限流阈值时的处理方式
+type RateLimitAction int
+
+const (
+	// RateLimitDrop 丢弃消息并向客户端发送警告帧（默认）
+	// 每个连接每秒最多发送一个警告帧，避免被限流的客户端放大下行流量
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitClose 以ClosePolicyViolation关闭连接
+	RateLimitClose
+)
+
+// RateLimit 上行限流阈值，字段为0表示不限制
+type RateLimit struct {
+	MessagesPerSecond float64 // 每秒消息数
+	BytesPerSecond    float64 // 每秒字节数
+	MessageBurst      float64 // 消息数突发容量，为0时等于MessagesPerSecond；小于1时按1计算
+	ByteBurst         float64 // 字节数突发容量，为0时等于BytesPerSecond；小于最大消息大小时按最大消息大小计算
+}
+
+// WithRateLimit 设置本地令牌桶上行限流：perConn按连接限流，perUser按用户（该用户在本实例的全部连接）限流
+func WithRateLimit(perConn, perUser RateLimit, action RateLimitAction) ManagerOption {
+	return func(manager *Manager) {
+		manager.options.connRateLimit = perConn
+		manager.options.userRateLimit = perUser
+		manager.options.rateLimitAction = action
+	}
+}
+
+// WithInboundLimiter 使用limiter.Limiter按用户（匿名连接按连接）对上行消息数限流，
+// 配合RedisSlidingWindowLimiter可实现跨实例的用户级限流；超限时的处理方式同WithRateLimit
+func WithInboundLimiter(l limiter.Limiter, prefix string) ManagerOption {
+	return func(manager *Manager) {
+		manager.inboundLimiter = l
+		manager.inboundPrefix = prefix
+	}
+}
+
+// allowInbound 检查上行消息是否超过限流阈值
+func (client *Client) allowInbound(size int) bool {
+	manager := client.Manager
+	if client.limiter != nil && !client.limiter.allow(size) {
+		return false
+	}
+	if client.UID != 0 {
+		if l := manager.userLimiter(client.UID); l != nil && !l.allow(size) {
+			return false
+		}
+	}
+	if manager.inboundLimiter != nil {
+		key := fmt.Sprintf("%s:conn:%s", manager.inboundPrefix, client.ID)
+		if client.UID != 0 {
+			key = fmt.Sprintf("%s:user:%d", manager.inboundPrefix, client.UID)
+		}
+		limited, err := manager.inboundLimiter.Limit(client.ctx, key)
+		if err != nil {
+			// 限流器出错时不限流，尽量服务正常的用户
+			manager.l.Error("websocket上行限流器出错", append(client.logFields(), loggerx.Error(err))...)
+			return true
+		}
+		return !limited
+	}
+	return true
+}
+
+// rejectInbound 处理超过限流阈值的上行消息，返回是否需要断开连接
+func (client *Client) rejectInbound() bool {
+	client.Manager.l.Warn("websocket上行消息超过限流阈值", client.logFields()...)
+	if client.Manager.options.rateLimitAction == RateLimitClose {
+		client.closeWith(websocket.ClosePolicyViolation, "rate limit exceeded")
+		if client.Conn != nil {
+			client.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+				time.Now().Add(client.Manager.options.writeTimeout))
+		}
+		return true
+	}
+	// rejectInbound只在读协程中调用，lastWarn无需加锁
+	if now := time.Now(); now.Sub(client.lastWarn) >= rateLimitWarnInterval {
+		client.lastWarn = now
+		client.emitError("", NewEventError(ErrCodeRateLimited, "rate limit exceeded, message dropped"))
+	}
+	return false
+}
+
+// userLimiter 返回用户级限流器，未设置用户级限流时返回nil
+func (manager *Manager) userLimiter(uid int64) *inboundLimiter {
+	if !manager.options.userRateLimit.enabled() {
+		return nil
+	}
+	manager.limitMu.Lock()
+	defer manager.limitMu.Unlock()
+	l, ok := manager.userLimiters[uid]
+	if !ok {
+		l = newInboundLimiter(manager.options.userRateLimit, manager.options.maxMessageSize)
+		manager.userLimiters[uid] = l
+	}
+	return l
+}
+
+// removeUserLimiter 用户在本实例的最后一个连接断开后删除其限流器
+func (manager *Manager) removeUserLimiter(uid int64) {
+	manager.limitMu.Lock()
+	defer manager.limitMu.Unlock()
+	delete(manager.userLimiters, uid)
+}
+
+func (r RateLimit) enabled() bool {
+	return r.MessagesPerSecond > 0 || r.BytesPerSecond > 0
+}
+
+// inboundLimiter 消息数与字节数的组合限流器
+type inboundLimiter struct {
+	mu       sync.Mutex
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newInboundLimiter(r RateLimit, maxMessageSize int64) *inboundLimiter {
+	if !r.enabled() {
+		return nil
+	}
+	// 消息数桶容量小于1时（如每秒0.5条），桶中永远凑不满一个令牌
+	messageBurst := r.MessageBurst
+	if messageBurst <= 0 {
+		messageBurst = r.MessagesPerSecond
+	}
+	messageBurst = math.Max(messageBurst, 1)
+	// 字节数桶容量小于最大消息大小时，较大的消息永远无法通过限流
+	byteBurst := r.ByteBurst
+	if byteBurst <= 0 {
+		byteBurst = r.BytesPerSecond
+	}
+	byteBurst = math.Max(byteBurst, float64(maxMessageSize))
+	return &inboundLimiter{
+		messages: newTokenBucket(r.MessagesPerSecond, messageBurst),
+		bytes:    newTokenBucket(r.BytesPerSecond, byteBurst),
+	}
+}
+
+// allow 同时满足消息数与字节数限制时放行并扣减令牌
+func (l *inboundLimiter) allow(size int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.messages.refill(now)
+	l.bytes.refill(now)
+	if !l.messages.has(1) || !l.bytes.has(float64(size)) {
+		return false
+	}
+	l.messages.take(1)
+	l.bytes.take(float64(size))
+	return true
+}
+
+// tokenBucket 令牌桶，为nil时不限制
+type tokenBucket struct {
+	rate   float64 // 每秒生成的令牌数
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) has(n float64) bool {
+	return b == nil || b.tokens >= n
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
@@ -0,0 +1,321 @@
+package websocket
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ginx "github.com/LEILEI0628/GinPro/GinX"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed outbox.lua
+var outboxLua string
+
+const (
+	// AckEventType 客户端确认消息的事件类型，data为{"seq": N}，表示N及之前的消息均已收到
+	AckEventType = "ack"
+	// LastSeqParam 重连时携带最后收到的序号的查询参数，如 /ws?last_seq=42
+	LastSeqParam = "last_seq"
+	// lastEventIDHeader SSE重连时浏览器自动携带的最后事件ID
+	lastEventIDHeader = "Last-Event-ID"
+	// reliableLockStripes 可靠消息按用户串行发送的分段锁数量
+	reliableLockStripes = 64
+	// defaultOutboxCapacity 每个用户默认最多保留的消息数
+	defaultOutboxCapacity = 100
+	// defaultOutboxTTL 发件箱默认保留时间
+	defaultOutboxTTL = 24 * time.Hour
+)
+
+// Ack 客户端确认
+type Ack struct {
+	Seq uint64 `json:"seq"`
+}
+
+// OutboxEntry 发件箱中的一条消息
+type OutboxEntry struct {
+	Seq  uint64
+	Data []byte // 已编码的消息信封
+}
+
+// Outbox 按用户存储可靠消息的有界发件箱
+type Outbox interface {
+	// Append 为事件分配该用户下一个序号（写入event.Seq）并存储编码后的信封
+	Append(ctx context.Context, uid int64, event *Event) (OutboxEntry, error)
+	// Since 返回序号大于seq的全部消息（按序号递增）
+	Since(ctx context.Context, uid int64, seq uint64) ([]OutboxEntry, error)
+	// Ack 删除序号不大于seq的消息
+	Ack(ctx context.Context, uid int64, seq uint64) error
+}
+
+// WithOutbox 设置发件箱，开启可靠投递（SendReliable、ack与重连补发）
+func WithOutbox(outbox Outbox) ManagerOption {
+	return func(manager *Manager) {
+		manager.outbox = outbox
+	}
+}
+
+// WithLastSeq 设置客户端最后收到的序号，连接建立后补发之后的消息
+// 默认从LastSeqParam查询参数或Last-Event-ID请求头读取
+func WithLastSeq(seq uint64) ClientOption {
+	return func(client *Client) {
+		client.lastSeq = seq
+		client.resume = true
+	}
+}
+
+// SendReliable 向用户发送可靠消息：消息带有按用户单调递增的序号并写入发件箱，
+// 用户离线或重连期间错过的消息会在重连时补发，客户端通过AckEventType确认
+// 注：补发与实时投递之间可能存在少量重复，客户端应按seq去重；
+// 同一实例内同一用户的消息按序号顺序投递，多实例同时向同一用户发送时实时消息的到达顺序不保证
+func (manager *Manager) SendReliable(ctx context.Context, uid int64, typ string, payload any) (uint64, error) {
+	if manager.outbox == nil {
+		return 0, errors.New("websocket manager未设置outbox")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	// 分配序号与投递需在同一把锁内完成，否则并发发送时序号大的消息可能先被投递
+	mu := &manager.reliableMu[uint64(uid)%reliableLockStripes]
+	mu.Lock()
+	defer mu.Unlock()
+	entry, err := manager.outbox.Append(ctx, uid, &Event{Type: typ, Data: data})
+	if err != nil {
+		return 0, err
+	}
+	manager.Deliver(&Delivery{Target: TargetUser, UID: uid, Seq: entry.Seq, Data: entry.Data})
+	return entry.Seq, nil
+}
+
+// Ack 确认用户已收到seq及之前的全部消息
+func (manager *Manager) Ack(ctx context.Context, uid int64, seq uint64) error {
+	if manager.outbox == nil || uid == 0 {
+		return nil
+	}
+	return manager.outbox.Ack(ctx, uid, seq)
+}
+
+// prepareReplay 读取客户端重连前错过的消息，由写协程先于实时消息写入
+// 补发消息不经过发送队列，积压超过发送队列容量时也不会触发慢消费者策略
+func (manager *Manager) prepareReplay(request *http.Request, client *Client) {
+	if manager.outbox == nil || client.UID == 0 {
+		return
+	}
+	if !client.resume {
+		val := request.URL.Query().Get(LastSeqParam)
+		if val == "" {
+			val = request.Header.Get(lastEventIDHeader)
+		}
+		if val == "" {
+			return
+		}
+		seq, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return
+		}
+		client.lastSeq, client.resume = seq, true
+	}
+	entries, err := manager.outbox.Since(request.Context(), client.UID, client.lastSeq)
+	if err != nil {
+		manager.l.Error("websocket读取补发消息失败", append(client.logFields(), loggerx.Error(err))...)
+		return
+	}
+	client.replay = entries
+	if len(entries) > 0 {
+		client.lastSeq = entries[len(entries)-1].Seq
+	}
+}
+
+// replayGap 补发读取发件箱与注册之间新写入的消息（需在写协程启动前调用）
+func (manager *Manager) replayGap(ctx context.Context, client *Client) {
+	if manager.outbox == nil || !client.resume {
+		return
+	}
+	entries, err := manager.outbox.Since(ctx, client.UID, client.lastSeq)
+	if err != nil {
+		manager.l.Error("websocket读取补发消息失败", append(client.logFields(), loggerx.Error(err))...)
+		return
+	}
+	client.replay = append(client.replay, entries...)
+}
+
+// message 转换为出站消息
+func (entry OutboxEntry) message() *Message {
+	return &Message{Type: TextMessage, Data: entry.Data, Seq: entry.Seq}
+}
+
+// MemoryOutbox 基于内存的发件箱，只适用于单实例部署
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	capacity int // 每个用户最多保留的消息数
+	users    map[int64]*memoryUserOutbox
+}
+
+type memoryUserOutbox struct {
+	seq     uint64
+	entries []OutboxEntry
+}
+
+// NewMemoryOutbox 创建基于内存的发件箱，capacity为每个用户最多保留的消息数，非正数时使用默认值100
+func NewMemoryOutbox(capacity int) *MemoryOutbox {
+	if capacity <= 0 {
+		capacity = defaultOutboxCapacity
+	}
+	return &MemoryOutbox{
+		capacity: capacity,
+		users:    make(map[int64]*memoryUserOutbox),
+	}
+}
+
+func (o *MemoryOutbox) Append(ctx context.Context, uid int64, event *Event) (OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	box, ok := o.users[uid]
+	if !ok {
+		box = &memoryUserOutbox{}
+		o.users[uid] = box
+	}
+	box.seq++
+	event.Seq = box.seq
+	data, err := json.Marshal(event)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	entry := OutboxEntry{Seq: box.seq, Data: data}
+	box.entries = append(box.entries, entry)
+	if len(box.entries) > o.capacity {
+		// 超出容量，丢弃最早的消息
+		box.entries = box.entries[len(box.entries)-o.capacity:]
+	}
+	return entry, nil
+}
+
+func (o *MemoryOutbox) Since(ctx context.Context, uid int64, seq uint64) ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	box, ok := o.users[uid]
+	if !ok {
+		return nil, nil
+	}
+	res := make([]OutboxEntry, 0, len(box.entries))
+	for _, entry := range box.entries {
+		if entry.Seq > seq {
+			res = append(res, entry)
+		}
+	}
+	return res, nil
+}
+
+func (o *MemoryOutbox) Ack(ctx context.Context, uid int64, seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	box, ok := o.users[uid]
+	if !ok {
+		return nil
+	}
+	i := 0
+	for i < len(box.entries) && box.entries[i].Seq <= seq {
+		i++
+	}
+	box.entries = box.entries[i:]
+	return nil
+}
+
+// RedisOutbox 基于Redis Stream的发件箱，多实例部署时用户重连到任意实例都能补发
+// 序号由INCR生成并作为Stream消息ID，与写入Stream在Lua脚本中原子完成；
+// 序号计数器不过期，每个用户常驻一个键；需要Redis 6.2及以上版本（XTRIM MINID）
+type RedisOutbox struct {
+	client   redis.Cmdable
+	prefix   string
+	capacity int64         // 每个用户最多保留的消息数
+	ttl      time.Duration // 用户无新消息后发件箱Stream的保留时间
+}
+
+// NewRedisOutbox 创建基于Redis Stream的发件箱，capacity为非正数、ttl不足1毫秒时分别使用默认值100与24小时
+func NewRedisOutbox(client redis.Cmdable, prefix string, capacity int64, ttl time.Duration) *RedisOutbox {
+	if capacity <= 0 {
+		capacity = defaultOutboxCapacity
+	}
+	if ttl < time.Millisecond {
+		// PEXPIRE的精度为毫秒，不足1毫秒时Stream会在写入后立即被删除
+		ttl = defaultOutboxTTL
+	}
+	return &RedisOutbox{
+		client:   client,
+		prefix:   prefix,
+		capacity: capacity,
+		ttl:      ttl,
+	}
+}
+
+func (o *RedisOutbox) Append(ctx context.Context, uid int64, event *Event) (OutboxEntry, error) {
+	event.Seq = 0
+	data, err := json.Marshal(event)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	seq, err := o.client.Eval(ctx, outboxLua, []string{o.seqKey(uid), o.streamKey(uid)},
+		data, o.capacity, o.ttl.Milliseconds()).Uint64()
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	event.Seq = seq
+	return withSeq(data, seq)
+}
+
+func (o *RedisOutbox) Since(ctx context.Context, uid int64, seq uint64) ([]OutboxEntry, error) {
+	msgs, err := o.client.XRange(ctx, o.streamKey(uid), fmt.Sprintf("%d-0", seq+1), "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]OutboxEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		id, _, _ := strings.Cut(msg.ID, "-")
+		s, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		data, _ := msg.Values["data"].(string)
+		entry, err := withSeq([]byte(data), s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+func (o *RedisOutbox) Ack(ctx context.Context, uid int64, seq uint64) error {
+	return o.client.XTrimMinID(ctx, o.streamKey(uid), fmt.Sprintf("%d-0", seq+1)).Err()
+}
+
+func (o *RedisOutbox) seqKey(uid int64) string {
+	return ginx.ClusterKey(o.prefix, "seq", uid)
+}
+
+func (o *RedisOutbox) streamKey(uid int64) string {
+	return ginx.ClusterKey(o.prefix, "outbox", uid)
+}
+
+// withSeq 将序号写入Stream中存储的消息信封
+func withSeq(data []byte, seq uint64) (OutboxEntry, error) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return OutboxEntry{}, err
+	}
+	event.Seq = seq
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	return OutboxEntry{Seq: seq, Data: data}, nil
+}
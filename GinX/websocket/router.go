@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrorEventType 错误帧的消息类型
+const ErrorEventType = "error"
+
+// 错误帧的错误码
+const (
+	ErrCodeBadRequest   = "bad_request"   // 消息格式错误或data无法解析
+	ErrCodeUnknownType  = "unknown_type"  // 没有对应类型的处理器
+	ErrCodeHandlerError = "handler_error" // 处理器返回了错误
+)
+
+// Event 消息信封：{type, id, data}
+type Event struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`  // 由客户端生成，错误帧会带回对应的ID
+	Seq   uint64          `json:"seq,omitempty"` // 可靠消息的序号
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *EventError     `json:"error,omitempty"`
+}
+
+// EventError 结构化错误，处理器返回*EventError时会原样写入错误帧
+type EventError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewEventError 创建结构化错误
+func NewEventError(code, message string) *EventError {
+	return &EventError{Code: code, Message: message}
+}
+
+// EncodeEvent 将负载编码为消息信封，可配合Manager的广播/房间/用户发送使用
+func EncodeEvent(typ string, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&Event{Type: typ, Data: data})
+}
+
+// eventHandler 已解码信封的处理函数
+type eventHandler func(client *Client, event *Event) error
+
+// Router 按消息类型分发的路由器
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[string]eventHandler
+}
+
+// NewRouter 创建路由器
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]eventHandler)}
+}
+
+// On 注册类型为typ的消息处理器，data会被解码为T
+// 注：Go的方法不支持类型参数，因此以包函数形式提供：
+//
+//	websocket.On(router, "chat.send", func(c *websocket.Client, msg ChatMessage) error {...})
+func On[T any](router *Router, typ string, fn func(client *Client, msg T) error) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.handlers[typ] = func(client *Client, event *Event) error {
+		var msg T
+		if len(event.Data) > 0 {
+			if err := json.Unmarshal(event.Data, &msg); err != nil {
+				return NewEventError(ErrCodeBadRequest, err.Error())
+			}
+		}
+		return fn(client, msg)
+	}
+}
+
+// ReadFunc 返回可传给ServeWs的读取处理方法：
+//
+//	websocket.ServeWs(manager, w, r, router.ReadFunc(), nil)
+func (router *Router) ReadFunc() func(client *Client, messageType int, message []byte, err error) error {
+	return func(client *Client, messageType int, message []byte, err error) error {
+		router.Dispatch(client, message)
+		return nil
+	}
+}
+
+// Dispatch 解码消息信封并交给对应的处理器，出错时向客户端回写错误帧
+func (router *Router) Dispatch(client *Client, message []byte) {
+	var event Event
+	if err := json.Unmarshal(message, &event); err != nil {
+		client.emitError("", NewEventError(ErrCodeBadRequest, err.Error()))
+		return
+	}
+	// 服务端发起调用的响应
+	if (event.Type == ResponseEventType || event.Type == ErrorEventType) && client.resolveCall(&event) {
+		return
+	}
+	router.mu.RLock()
+	handler, ok := router.handlers[event.Type]
+	router.mu.RUnlock()
+	if !ok {
+		// 未注册自定义处理器时，由内置处理器处理
+		switch event.Type {
+		case AckEventType:
+			handler, ok = ackHandler, true
+		case CancelEventType:
+			client.cancelInbound(event.ID)
+			return
+		}
+	}
+	if !ok {
+		client.emitError(event.ID, NewEventError(ErrCodeUnknownType, "unknown event type: "+event.Type))
+		return
+	}
+	if err := handler(client, &event); err != nil {
+		var ee *EventError
+		if !errors.As(err, &ee) {
+			ee = NewEventError(ErrCodeHandlerError, err.Error())
+		}
+		client.emitError(event.ID, ee)
+	}
+}
+
+// ackHandler 处理客户端确认，删除发件箱中已确认的消息
+func ackHandler(client *Client, event *Event) error {
+	var ack Ack
+	if err := json.Unmarshal(event.Data, &ack); err != nil {
+		return NewEventError(ErrCodeBadRequest, err.Error())
+	}
+	return client.Manager.Ack(context.Background(), client.UID, ack.Seq)
+}
+
+// Emit 向客户端推送类型化事件
+func (client *Client) Emit(typ string, payload any) error {
+	message, err := EncodeEvent(typ, payload)
+	if err != nil {
+		return err
+	}
+	client.Manager.SendToClient(client, NewTextMessage(message))
+	return nil
+}
+
+// emitError 向客户端回写错误帧
+func (client *Client) emitError(id string, e *EventError) {
+	client.emitEvent(&Event{Type: ErrorEventType, ID: id, Error: e})
+}
@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// ResponseEventType 调用成功的响应帧类型，id与请求相同
+	ResponseEventType = "response"
+	// CancelEventType 取消调用的事件类型，id为要取消的请求
+	CancelEventType = "cancel"
+	// defaultRPCTimeout 默认调用超时时间
+	defaultRPCTimeout = 10 * time.Second
+)
+
+// RPC错误帧的错误码
+const (
+	ErrCodeTimeout  = "timeout"  // 调用超时
+	ErrCodeCanceled = "canceled" // 调用被取消
+)
+
+// ErrClientClosed 客户端已断开
+var ErrClientClosed = errors.New("websocket client已断开")
+
+// WithRPCTimeout 设置调用的默认超时时间（默认10s），调用方ctx已设置截止时间时以ctx为准，非正数时忽略
+func WithRPCTimeout(d time.Duration) ManagerOption {
+	return func(manager *Manager) {
+		if d > 0 {
+			manager.options.rpcTimeout = d
+		}
+	}
+}
+
+// HandleRPC 注册供客户端调用的服务端方法：
+// 客户端发送{type: method, id, data}，成功时返回{type: "response", id, data}，失败时返回错误帧；
+// 处理方法在独立协程中执行，ctx在超时、客户端发送{type: "cancel", id}或连接断开时取消
+func HandleRPC[Req any, Resp any](router *Router, method string, fn func(ctx context.Context, client *Client, req Req) (Resp, error)) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.handlers[method] = func(client *Client, event *Event) error {
+		var req Req
+		if len(event.Data) > 0 {
+			if err := json.Unmarshal(event.Data, &req); err != nil {
+				return NewEventError(ErrCodeBadRequest, err.Error())
+			}
+		}
+		ctx, cancel := context.WithTimeout(client.ctx, client.Manager.options.rpcTimeout)
+		id := event.ID
+		if id != "" {
+			client.rpcMu.Lock()
+			client.inflight[id] = cancel
+			client.rpcMu.Unlock()
+		}
+		go func() {
+			defer func() {
+				cancel()
+				if id != "" {
+					client.rpcMu.Lock()
+					delete(client.inflight, id)
+					client.rpcMu.Unlock()
+				}
+			}()
+			resp, err := fn(ctx, client, req)
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				client.emitError(id, rpcError(err))
+				return
+			}
+			data, err := json.Marshal(resp)
+			if err != nil {
+				client.emitError(id, NewEventError(ErrCodeHandlerError, err.Error()))
+				return
+			}
+			client.emitEvent(&Event{Type: ResponseEventType, ID: id, Data: data})
+		}()
+		return nil
+	}
+}
+
+// Call 调用客户端方法并等待响应：
+// 发送{type: method, id, data}，客户端应返回{type: "response", id, data}或错误帧；
+// ctx未设置截止时间时使用默认超时时间，超时或取消时会通知客户端取消
+// 注：响应由Router分发，需要使用router.ReadFunc()作为读取处理方法
+func (client *Client) Call(ctx context.Context, method string, req any) (json.RawMessage, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Manager.options.rpcTimeout)
+		defer cancel()
+	}
+	id := newID()
+	ch := make(chan *Event, 1)
+	client.rpcMu.Lock()
+	client.calls[id] = ch
+	client.rpcMu.Unlock()
+	defer func() {
+		client.rpcMu.Lock()
+		delete(client.calls, id)
+		client.rpcMu.Unlock()
+	}()
+
+	client.emitEvent(&Event{Type: method, ID: id, Data: data})
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Data, nil
+	case <-ctx.Done():
+		client.emitEvent(&Event{Type: CancelEventType, ID: id})
+		return nil, ctx.Err()
+	case <-client.ctx.Done():
+		return nil, ErrClientClosed
+	}
+}
+
+// CallAs 调用客户端方法并将响应解码为Resp
+func CallAs[Resp any](ctx context.Context, client *Client, method string, req any) (Resp, error) {
+	var resp Resp
+	data, err := client.Call(ctx, method, req)
+	if err != nil {
+		return resp, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &resp)
+	}
+	return resp, err
+}
+
+// Context 返回连接的上下文，连接断开时取消
+func (client *Client) Context() context.Context {
+	return client.ctx
+}
+
+// resolveCall 将响应或错误帧交给等待中的Call，返回是否匹配到调用
+func (client *Client) resolveCall(event *Event) bool {
+	if event.ID == "" {
+		return false
+	}
+	client.rpcMu.Lock()
+	ch, ok := client.calls[event.ID]
+	client.rpcMu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- event:
+	default:
+	}
+	return true
+}
+
+// cancelInbound 取消客户端发起的调用
+func (client *Client) cancelInbound(id string) {
+	client.rpcMu.Lock()
+	cancel, ok := client.inflight[id]
+	client.rpcMu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// emitEvent 向客户端发送消息信封
+func (client *Client) emitEvent(event *Event) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	client.Manager.SendToClient(client, NewTextMessage(message))
+}
+
+// rpcError 将处理方法返回的错误转换为结构化错误
+func rpcError(err error) *EventError {
+	var ee *EventError
+	switch {
+	case errors.As(err, &ee):
+		return ee
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewEventError(ErrCodeTimeout, err.Error())
+	case errors.Is(err, context.Canceled):
+		return NewEventError(ErrCodeCanceled, err.Error())
+	default:
+		return NewEventError(ErrCodeHandlerError, err.Error())
+	}
+}
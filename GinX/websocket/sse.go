@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// sseRetry 建议浏览器断线重连的间隔（毫秒）
+	sseRetry = 3000
+	// sseBinaryEvent 二进制消息的SSE事件名，data为base64编码
+	sseBinaryEvent = "binary"
+	// sseCloseEvent 服务端主动断开时发送的SSE事件名，data为关闭原因
+	sseCloseEvent = "close"
+)
+
+// ErrStreamingUnsupported ResponseWriter不支持流式响应
+var ErrStreamingUnsupported = errors.New("response writer不支持flush")
+
+// ServeSSE 处理Server-Sent Events请求，用于WebSocket升级被代理拦截的场景
+// SSE订阅者与WebSocket客户端注册到同一个Manager，广播、房间及用户消息对两种传输方式透明；
+// 支持通过Last-Event-ID补发可靠消息（需设置WithOutbox），并定期发送注释行作为心跳
+// 注：SSE是单向的，客户端上行消息需通过普通HTTP接口发送；该方法会阻塞直到连接断开
+func ServeSSE(manager *Manager, response http.ResponseWriter, request *http.Request, opts ...ClientOption) error {
+	if manager.closing.Load() {
+		return ErrManagerClosed
+	}
+	if _, ok := response.(http.Flusher); !ok {
+		return ErrStreamingUnsupported
+	}
+	header := response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 禁用Nginx缓冲
+	header.Set("X-Accel-Buffering", "no")
+
+	client := newClient(manager, TransportSSE, request)
+	for _, opt := range opts {
+		opt(client)
+	}
+	manager.prepareReplay(request, client)
+	if err := manager.register(client); err != nil {
+		return err
+	}
+	manager.replayGap(request.Context(), client)
+	manager.onConnect(client)
+
+	err := client.writeSSE(response, request)
+	manager.unregister(client)
+	client.cancel()
+	manager.onDisconnect(client, client.disconnectReason(err))
+	return nil
+}
+
+// writeSSE 将发送队列中的消息写为SSE事件，直到连接断开或被Manager移除
+func (client *Client) writeSSE(response http.ResponseWriter, request *http.Request) error {
+	opts := &client.Manager.options
+	rc := http.NewResponseController(response)
+	ticker := time.NewTicker(opts.heartBeatTime())
+	defer func() {
+		ticker.Stop()
+		client.Manager.writers.Done()
+	}()
+
+	write := func(buf []byte) error {
+		// 不支持写入截止时间的ResponseWriter忽略该错误
+		_ = rc.SetWriteDeadline(time.Now().Add(opts.writeTimeout))
+		if _, err := response.Write(buf); err != nil {
+			client.Manager.onError(client, "sse写入数据失败", err)
+			return err
+		}
+		return rc.Flush()
+	}
+
+	response.WriteHeader(http.StatusOK)
+	if err := write([]byte(fmt.Sprintf("retry: %d\n\n", sseRetry))); err != nil {
+		return err
+	}
+	// 先写入重连补发的消息，再写入发送队列中的实时消息
+	for _, entry := range client.replay {
+		if err := write(encodeSSE("", entry.Seq, entry.Data)); err != nil {
+			return err
+		}
+	}
+	client.replay = nil
+	for {
+		select {
+		case message, ok := <-client.Send:
+			if !ok {
+				// 被Manager移除，通知浏览器后结束响应
+				_, reason := client.closeInfo()
+				write(encodeSSE(sseCloseEvent, 0, []byte(reason)))
+				return nil
+			}
+			event, data := "", message.Data
+			if message.Type == BinaryMessage {
+				event, data = sseBinaryEvent, []byte(base64.StdEncoding.EncodeToString(message.Data))
+			}
+			if err := write(encodeSSE(event, message.Seq, data)); err != nil {
+				return err
+			}
+		case <-ticker.C: // 心跳注释，防止代理断开空闲连接
+			if err := write([]byte(": ping\n\n")); err != nil {
+				return err
+			}
+		case <-request.Context().Done():
+			// 浏览器断开
+			return request.Context().Err()
+		case <-client.ctx.Done():
+			// Stop超时后强制关闭
+			return client.ctx.Err()
+		}
+	}
+}
+
+// encodeSSE 编码一个SSE事件，多行数据拆分为多个data字段
+func encodeSSE(event string, id uint64, data []byte) []byte {
+	var buf bytes.Buffer
+	if id > 0 {
+		fmt.Fprintf(&buf, "id: %d\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", event)
+	}
+	for _, line := range bytes.Split(data, LINE) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte{'\r'}))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
@@ -0,0 +1,286 @@
+// Package wstest 提供基于httptest的WebSocket测试工具，
+// 用于为基于websocket.Manager的房间、广播、鉴权等逻辑编写确定性的测试
+package wstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LEILEI0628/GinPro/GinX/websocket"
+	gws "github.com/gorilla/websocket"
+)
+
+// DefaultTimeout 等待消息、连接及断开的默认超时时间
+const DefaultTimeout = 2 * time.Second
+
+// HandlerFunc 处理WebSocket升级请求，默认为websocket.DefaultServeWs
+type HandlerFunc func(manager *websocket.Manager, response http.ResponseWriter, request *http.Request) error
+
+// Server 运行Manager的测试服务器
+type Server struct {
+	Manager *websocket.Manager
+	HTTP    *httptest.Server
+	URL     string // ws://地址
+
+	t testing.TB
+}
+
+// NewServer 启动Manager及httptest服务器，测试结束时自动关闭
+// handler为nil时使用websocket.DefaultServeWs
+func NewServer(t testing.TB, handler HandlerFunc, opts ...websocket.ManagerOption) *Server {
+	t.Helper()
+	if handler == nil {
+		handler = func(manager *websocket.Manager, response http.ResponseWriter, request *http.Request) error {
+			return websocket.DefaultServeWs(manager, response, request)
+		}
+	}
+	manager := websocket.NewWsManager(opts...)
+	go manager.Run()
+	srv := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if err := handler(manager, response, request); err != nil {
+			t.Logf("wstest: handler error: %v", err)
+		}
+	}))
+	s := &Server{
+		Manager: manager,
+		HTTP:    srv,
+		URL:     "ws" + strings.TrimPrefix(srv.URL, "http"),
+		t:       t,
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer cancel()
+		_ = manager.Stop(ctx, "test finished")
+		srv.Close()
+	})
+	return s
+}
+
+// Dial 连接服务器并等待客户端注册到Manager，path可携带查询参数（如"/?last_seq=3"）
+func (s *Server) Dial(path string, header http.Header) *Conn {
+	s.t.Helper()
+	before := s.Manager.ClientCount()
+	conn, err := s.DialErr(path, header)
+	if err != nil {
+		s.t.Fatalf("wstest: dial failed: %v", err)
+	}
+	s.WaitForClients(before + 1)
+	return conn
+}
+
+// DialErr 连接服务器并返回错误，用于断言鉴权失败等场景
+func (s *Server) DialErr(path string, header http.Header) (*Conn, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("Origin") == "" {
+		// 默认同源
+		header.Set("Origin", s.HTTP.URL)
+	}
+	raw, resp, err := gws.DefaultDialer.Dial(s.URL+path, header)
+	if err != nil {
+		if resp != nil {
+			return nil, &DialError{StatusCode: resp.StatusCode, Err: err}
+		}
+		return nil, err
+	}
+	c := &Conn{
+		t:      s.t,
+		raw:    raw,
+		frames: make(chan Frame, 256),
+		closed: make(chan struct{}),
+	}
+	go c.readLoop()
+	s.t.Cleanup(func() { c.raw.Close() })
+	return c, nil
+}
+
+// WaitForClients 等待Manager中的客户端数量达到n
+func (s *Server) WaitForClients(n int) {
+	s.t.Helper()
+	deadline := time.Now().Add(DefaultTimeout)
+	for s.Manager.ClientCount() != n {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("wstest: expected %d clients, got %d", n, s.Manager.ClientCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// DialError 握手失败，携带HTTP状态码
+type DialError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *DialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// Frame 收到的一帧消息
+// 注：服务端默认写入方法只会将Broadcast通道中排队的聊天消息以换行合并为一帧，消息信封总是单独成帧
+type Frame struct {
+	Type int
+	Data []byte
+}
+
+// Event 将消息解码为消息信封，解码失败时返回nil
+func (f Frame) Event() *websocket.Event {
+	var event websocket.Event
+	if err := json.Unmarshal(f.Data, &event); err != nil {
+		return nil
+	}
+	return &event
+}
+
+// Conn 测试客户端连接
+type Conn struct {
+	t        testing.TB
+	raw      *gws.Conn
+	frames   chan Frame
+	closed   chan struct{}
+	closeErr error
+}
+
+// Raw 返回底层连接
+func (c *Conn) Raw() *gws.Conn {
+	return c.raw
+}
+
+// Send 发送文本消息
+func (c *Conn) Send(data []byte) {
+	c.t.Helper()
+	if err := c.raw.WriteMessage(gws.TextMessage, data); err != nil {
+		c.t.Fatalf("wstest: send failed: %v", err)
+	}
+}
+
+// SendBinary 发送二进制消息
+func (c *Conn) SendBinary(data []byte) {
+	c.t.Helper()
+	if err := c.raw.WriteMessage(gws.BinaryMessage, data); err != nil {
+		c.t.Fatalf("wstest: send failed: %v", err)
+	}
+}
+
+// SendEvent 发送消息信封
+func (c *Conn) SendEvent(typ, id string, payload any) {
+	c.t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Fatalf("wstest: marshal payload failed: %v", err)
+	}
+	msg, _ := json.Marshal(&websocket.Event{Type: typ, ID: id, Data: data})
+	c.Send(msg)
+}
+
+// WaitFor 等待满足条件的消息，跳过不满足条件的消息，超时或连接断开时测试失败
+func (c *Conn) WaitFor(pred func(f Frame) bool) Frame {
+	c.t.Helper()
+	timer := time.NewTimer(DefaultTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case f, ok := <-c.frames:
+			if !ok {
+				c.t.Fatalf("wstest: connection closed while waiting for message: %v", c.closeErr)
+				return Frame{}
+			}
+			if pred(f) {
+				return f
+			}
+		case <-timer.C:
+			c.t.Fatalf("wstest: timed out waiting for message")
+			return Frame{}
+		}
+	}
+}
+
+// WaitForText 等待内容为text的文本消息
+func (c *Conn) WaitForText(text string) Frame {
+	c.t.Helper()
+	return c.WaitFor(func(f Frame) bool {
+		return f.Type == gws.TextMessage && string(f.Data) == text
+	})
+}
+
+// WaitForEvent 等待类型为typ的消息信封
+func (c *Conn) WaitForEvent(typ string) *websocket.Event {
+	c.t.Helper()
+	f := c.WaitFor(func(f Frame) bool {
+		event := f.Event()
+		return event != nil && event.Type == typ
+	})
+	return f.Event()
+}
+
+// ExpectNoMessage 断言在d时间内没有收到消息
+func (c *Conn) ExpectNoMessage(d time.Duration) {
+	c.t.Helper()
+	select {
+	case f, ok := <-c.frames:
+		if ok {
+			c.t.Fatalf("wstest: unexpected message: %q", f.Data)
+		}
+	case <-time.After(d):
+	}
+}
+
+// ExpectClose 等待连接断开并断言关闭状态码，跳过断开前收到的消息
+func (c *Conn) ExpectClose(code int) {
+	c.t.Helper()
+	select {
+	case <-c.closed:
+	case <-time.After(DefaultTimeout):
+		c.t.Fatalf("wstest: timed out waiting for close")
+		return
+	}
+	var ce *gws.CloseError
+	if !errors.As(c.closeErr, &ce) {
+		c.t.Fatalf("wstest: expected close code %d, got error: %v", code, c.closeErr)
+		return
+	}
+	if ce.Code != code {
+		c.t.Fatalf("wstest: expected close code %d, got %d (%s)", code, ce.Code, ce.Text)
+	}
+}
+
+// Close 发送正常关闭帧并断开连接
+func (c *Conn) Close() {
+	_ = c.raw.WriteControl(gws.CloseMessage,
+		gws.FormatCloseMessage(gws.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	_ = c.raw.Close()
+}
+
+func (c *Conn) readLoop() {
+	defer func() {
+		close(c.frames)
+		close(c.closed)
+	}()
+	for {
+		typ, data, err := c.raw.ReadMessage()
+		if err != nil {
+			c.closeErr = err
+			return
+		}
+		c.push(Frame{Type: typ, Data: data})
+	}
+}
+
+func (c *Conn) push(f Frame) {
+	select {
+	case c.frames <- f:
+	default:
+		// 测试未读取的消息过多时丢弃，避免阻塞读取
+	}
+}
@@ -0,0 +1,120 @@
+package wstest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LEILEI0628/GinPro/GinX/websocket"
+	gws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routerHandler 使用X-UID请求头绑定用户，并注册join事件加入房间、burst事件连续推送n个tick
+func routerHandler() HandlerFunc {
+	router := websocket.NewRouter()
+	websocket.On(router, "join", func(c *websocket.Client, room string) error {
+		c.Manager.Join(c, room)
+		return c.Emit("joined", room)
+	})
+	websocket.On(router, "burst", func(c *websocket.Client, n int) error {
+		for i := 0; i < n; i++ {
+			if err := c.Emit("tick", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return func(manager *websocket.Manager, response http.ResponseWriter, request *http.Request) error {
+		uid, _ := strconv.ParseInt(request.Header.Get("X-UID"), 10, 64)
+		return websocket.ServeWs(manager, response, request, router.ReadFunc(), nil, websocket.WithUID(uid))
+	}
+}
+
+func userHeader(uid int64) http.Header {
+	return http.Header{"X-UID": []string{strconv.FormatInt(uid, 10)}}
+}
+
+func TestBroadcast(t *testing.T) {
+	s := NewServer(t, nil)
+	c1 := s.Dial("/", nil)
+	c2 := s.Dial("/", nil)
+
+	c1.Send([]byte("hello"))
+	c1.WaitForText("hello")
+	c2.WaitForText("hello")
+
+	c2.SendBinary([]byte{0x00, '\n', 0xff})
+	f := c1.WaitFor(func(f Frame) bool { return f.Type == gws.BinaryMessage })
+	assert.Equal(t, []byte{0x00, '\n', 0xff}, f.Data)
+}
+
+func TestRoomsAndUsers(t *testing.T) {
+	s := NewServer(t, routerHandler())
+	alice := s.Dial("/", userHeader(1))
+	bob := s.Dial("/", userHeader(2))
+	aliceTab := s.Dial("/", userHeader(1))
+
+	alice.SendEvent("join", "1", "lobby")
+	alice.WaitForEvent("joined")
+	require.Len(t, s.Manager.RoomMembers("lobby"), 1)
+
+	s.Manager.BroadcastToRoom("lobby", []byte("room-msg"))
+	alice.WaitForText("room-msg")
+	bob.ExpectNoMessage(100 * time.Millisecond)
+
+	s.Manager.SendToUser(1, []byte("user-msg"))
+	alice.WaitForText("user-msg")
+	aliceTab.WaitForText("user-msg")
+
+	s.Manager.DisconnectUser(1)
+	alice.ExpectClose(gws.CloseNormalClosure)
+	s.WaitForClients(1)
+	assert.Empty(t, s.Manager.RoomMembers("lobby"))
+	assert.Empty(t, s.Manager.UserClients(1))
+}
+
+func TestEventsAreSeparateFrames(t *testing.T) {
+	s := NewServer(t, routerHandler())
+	c := s.Dial("/", nil)
+	c.SendEvent("burst", "1", 50)
+	// 排队的消息信封不会被合并，每一帧都能单独解析
+	for i := 0; i < 50; i++ {
+		f := c.WaitFor(func(f Frame) bool { return true })
+		event := f.Event()
+		require.NotNil(t, event, "frame: %s", f.Data)
+		assert.Equal(t, "tick", event.Type)
+		assert.JSONEq(t, strconv.Itoa(i), string(event.Data))
+	}
+}
+
+func TestUnknownEvent(t *testing.T) {
+	s := NewServer(t, routerHandler())
+	c := s.Dial("/", nil)
+	c.SendEvent("nope", "42", nil)
+	event := c.WaitForEvent(websocket.ErrorEventType)
+	assert.Equal(t, "42", event.ID)
+	assert.Equal(t, websocket.ErrCodeUnknownType, event.Error.Code)
+}
+
+func TestOriginRejected(t *testing.T) {
+	s := NewServer(t, nil)
+	_, err := s.DialErr("/", http.Header{"Origin": []string{"https://evil.example"}})
+	var de *DialError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, http.StatusForbidden, de.StatusCode)
+}
+
+func TestStop(t *testing.T) {
+	s := NewServer(t, nil)
+	c := s.Dial("/", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Manager.Stop(ctx, "deploy"))
+	c.ExpectClose(gws.CloseGoingAway)
+	_, err := s.DialErr("/", nil)
+	assert.Error(t, err)
+}
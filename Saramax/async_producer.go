@@ -0,0 +1,146 @@
+package saramax
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+)
+
+// DeliveryResult 异步发送的投递结果
+type DeliveryResult[T any] struct {
+	Topic     string
+	Key       string
+	Value     T
+	Partition int32
+	Offset    int64
+	Err       error // 为nil表示投递成功
+}
+
+// WithDeliveryCallback 设置投递结果回调（仅AsyncProducer有效），
+// 回调在结果处理协程中串行执行，不应长时间阻塞
+func WithDeliveryCallback[T any](fn func(result DeliveryResult[T])) ProducerOption[T] {
+	return func(opts *producerOptions[T]) {
+		opts.callback = fn
+	}
+}
+
+// WithResultChannel 通过Results()通道输出投递结果（仅AsyncProducer有效），
+// 开启后调用方必须持续消费该通道，否则会阻塞后续结果的处理
+func WithResultChannel[T any](size int) ProducerOption[T] {
+	return func(opts *producerOptions[T]) {
+		opts.results = size
+		if opts.results <= 0 {
+			opts.results = 1
+		}
+	}
+}
+
+// AsyncProducer 基于sarama.AsyncProducer的泛型异步生产者
+// 投递结果通过回调或Results()通道上报，两者都未设置时仅记录失败日志
+// 注：只有配置开启Producer.Return.Successes时才会上报成功结果
+type AsyncProducer[T any] struct {
+	l        loggerx.Logger
+	producer sarama.AsyncProducer
+	options  producerOptions[T]
+	results  chan DeliveryResult[T]
+	wg       sync.WaitGroup
+}
+
+// pending 随消息携带的原始数据，用于组装投递结果
+type pending[T any] struct {
+	key   string
+	value T
+}
+
+// NewAsyncProducer 创建异步生产者并启动投递结果处理协程
+func NewAsyncProducer[T any](l loggerx.Logger, producer sarama.AsyncProducer, opts ...ProducerOption[T]) *AsyncProducer[T] {
+	p := &AsyncProducer[T]{
+		l:        l,
+		producer: producer,
+		options:  newProducerOptions(opts),
+	}
+	if p.options.results > 0 {
+		p.results = make(chan DeliveryResult[T], p.options.results)
+	}
+	p.wg.Add(2)
+	go p.handleSuccesses()
+	go p.handleErrors()
+	return p
+}
+
+// Send 异步发送一条消息，消息进入发送队列即返回
+func (p *AsyncProducer[T]) Send(ctx context.Context, topic string, key string, t T, headers map[string]string) error {
+	key = p.options.key(key, t)
+	msg, err := p.options.message(topic, key, t, headers)
+	if err != nil {
+		return err
+	}
+	msg.Metadata = pending[T]{key: key, value: t}
+	select {
+	case p.producer.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Results 投递结果通道，仅在使用WithResultChannel时非nil，Close后关闭
+func (p *AsyncProducer[T]) Results() <-chan DeliveryResult[T] {
+	return p.results
+}
+
+// Close 关闭生产者，等待已发送消息的投递结果全部处理完毕
+// 注：Close后不能再调用Send
+func (p *AsyncProducer[T]) Close() error {
+	p.producer.AsyncClose()
+	p.wg.Wait()
+	if p.results != nil {
+		close(p.results)
+	}
+	return nil
+}
+
+func (p *AsyncProducer[T]) handleSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		p.report(msg, nil)
+	}
+}
+
+func (p *AsyncProducer[T]) handleErrors() {
+	defer p.wg.Done()
+	for pe := range p.producer.Errors() {
+		if p.options.callback == nil && p.results == nil {
+			p.l.Error("异步发送消息失败",
+				loggerx.Error(pe.Err),
+				loggerx.String("topic", pe.Msg.Topic))
+			continue
+		}
+		p.report(pe.Msg, pe.Err)
+	}
+}
+
+// report 上报投递结果
+func (p *AsyncProducer[T]) report(msg *sarama.ProducerMessage, err error) {
+	if p.options.callback == nil && p.results == nil {
+		return
+	}
+	result := DeliveryResult[T]{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Err:       err,
+	}
+	if meta, ok := msg.Metadata.(pending[T]); ok {
+		result.Key = meta.key
+		result.Value = meta.value
+	}
+	if p.options.callback != nil {
+		p.options.callback(result)
+	}
+	if p.results != nil {
+		p.results <- result
+	}
+}
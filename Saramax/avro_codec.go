@@ -0,0 +1,188 @@
+package saramax
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+// Confluent wire format：1字节魔数0 + 4字节大端schema ID + Avro二进制数据
+const (
+	wireMagicByte  = 0
+	wireHeaderSize = 5
+)
+
+var (
+	// ErrInvalidWireFormat 数据不是Confluent wire format
+	ErrInvalidWireFormat = errors.New("saramax: 无效的Confluent wire format数据")
+	// ErrSchemaNotFound schema注册中心中不存在该ID
+	ErrSchemaNotFound = errors.New("saramax: schema不存在")
+)
+
+// SchemaRegistry Avro schema注册中心，按ID查询schema
+// 生产环境可基于Confluent Schema Registry的HTTP接口实现，测试时使用FileSchemaRegistry
+type SchemaRegistry interface {
+	Schema(id int) (avro.Schema, error)
+}
+
+// AvroCodec Confluent wire format的Avro编解码器
+// 编码时写入固定的schema ID；解码时按数据中的schema ID从注册中心获取写入方schema，
+// 并与自身schema（读取方）按Avro规则解析，因此可以消费由其他兼容版本schema写入的消息：
+// 读取方新增字段使用其默认值，支持字段别名与类型提升（如int→long）；T的字段用avro tag指定
+type AvroCodec[T any] struct {
+	registry SchemaRegistry
+	schemaID int
+	schema   avro.Schema
+
+	mu       sync.RWMutex
+	resolved map[int]avro.Schema // 写入方schema ID -> 与读取方解析后的schema
+}
+
+// NewAvroCodec 创建Avro编解码器，schemaID为编码时使用的schema
+func NewAvroCodec[T any](registry SchemaRegistry, schemaID int) (*AvroCodec[T], error) {
+	schema, err := registry.Schema(schemaID)
+	if err != nil {
+		return nil, err
+	}
+	return &AvroCodec[T]{
+		registry: registry,
+		schemaID: schemaID,
+		schema:   schema,
+		resolved: make(map[int]avro.Schema),
+	}, nil
+}
+
+func (c *AvroCodec[T]) Marshal(t T) ([]byte, error) {
+	data, err := avro.Marshal(c.schema, t)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, wireHeaderSize, wireHeaderSize+len(data))
+	buf[0] = wireMagicByte
+	binary.BigEndian.PutUint32(buf[1:wireHeaderSize], uint32(c.schemaID))
+	return append(buf, data...), nil
+}
+
+func (c *AvroCodec[T]) Unmarshal(data []byte) (T, error) {
+	var t T
+	id, payload, err := ParseWireFormat(data)
+	if err != nil {
+		return t, err
+	}
+	schema, err := c.readerSchema(id)
+	if err != nil {
+		return t, err
+	}
+	err = avro.Unmarshal(schema, payload, &t)
+	return t, err
+}
+
+// readerSchema 返回解码写入方schema ID的数据时使用的schema，解析结果按ID缓存
+func (c *AvroCodec[T]) readerSchema(id int) (avro.Schema, error) {
+	if id == c.schemaID {
+		return c.schema, nil
+	}
+	c.mu.RLock()
+	schema, ok := c.resolved[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+	writer, err := c.registry.Schema(id)
+	if err != nil {
+		return nil, err
+	}
+	schema, err = avro.NewSchemaCompatibility().Resolve(c.schema, writer)
+	if err != nil {
+		return nil, fmt.Errorf("saramax: schema %d与%d不兼容: %w", id, c.schemaID, err)
+	}
+	c.mu.Lock()
+	c.resolved[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+// ParseWireFormat 解析Confluent wire format，返回schema ID和Avro数据
+func ParseWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < wireHeaderSize || data[0] != wireMagicByte {
+		return 0, nil, ErrInvalidWireFormat
+	}
+	return int(binary.BigEndian.Uint32(data[1:wireHeaderSize])), data[wireHeaderSize:], nil
+}
+
+// FileSchemaRegistry 基于本地目录的schema注册中心，用于测试和本地开发
+// 目录下的每个文件<id>.avsc对应一个schema，解析结果会被缓存
+type FileSchemaRegistry struct {
+	dir     string
+	mu      sync.RWMutex
+	schemas map[int]avro.Schema
+}
+
+// NewFileSchemaRegistry 创建基于本地目录的schema注册中心
+func NewFileSchemaRegistry(dir string) *FileSchemaRegistry {
+	return &FileSchemaRegistry{
+		dir:     dir,
+		schemas: make(map[int]avro.Schema),
+	}
+}
+
+func (r *FileSchemaRegistry) Schema(id int) (avro.Schema, error) {
+	r.mu.RLock()
+	schema, ok := r.schemas[id]
+	r.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+	data, err := os.ReadFile(filepath.Join(r.dir, strconv.Itoa(id)+".avsc"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: id=%d", ErrSchemaNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 每个schema使用独立的缓存，避免同名record的不同版本相互覆盖
+	schema, err = avro.ParseBytesWithCache(data, "", &avro.SchemaCache{})
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+	return schema, nil
+}
+
+// Register 写入schema文件并返回其ID（当前最大ID加1），用于测试中准备数据
+func (r *FileSchemaRegistry) Register(schema string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	parsed, err := avro.ParseBytesWithCache([]byte(schema), "", &avro.SchemaCache{})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return 0, err
+	}
+	id := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != ".avsc" {
+			continue
+		}
+		if n, err := strconv.Atoi(name[:len(name)-len(".avsc")]); err == nil && n > id {
+			id = n
+		}
+	}
+	id++
+	if err = os.WriteFile(filepath.Join(r.dir, strconv.Itoa(id)+".avsc"), []byte(schema), 0o644); err != nil {
+		return 0, err
+	}
+	r.schemas[id] = parsed
+	return id, nil
+}
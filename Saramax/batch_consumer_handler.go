@@ -2,22 +2,38 @@ package saramax
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"github.com/IBM/sarama"
 	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
-	"time"
 )
 
 type BatchHandler[T any] struct {
 	l  loggerx.Logger
-	fn func(msgs []*sarama.ConsumerMessage, ts []T) error
-	// 用option模式来设置batchSize和duration
-	batchSize     int
-	batchDuration time.Duration
+	fn func(msgs []*sarama.ConsumerMessage, ts []T) []error
+	// 批次大小、凑批时长、字节上限和重试策略通过HandlerOption设置
+	options handlerOptions[T]
 }
 
-func NewBatchHandler[T any](l loggerx.Logger, fn func(msgs []*sarama.ConsumerMessage, ts []T) error) *BatchHandler[T] {
-	return &BatchHandler[T]{l: l, fn: fn, batchDuration: time.Second, batchSize: 10}
+// NewBatchHandler 创建批量消费handler，fn返回错误时整个批次视为失败
+func NewBatchHandler[T any](l loggerx.Logger, fn func(msgs []*sarama.ConsumerMessage, ts []T) error, opts ...HandlerOption[T]) *BatchHandler[T] {
+	return NewPartialBatchHandler[T](l, func(msgs []*sarama.ConsumerMessage, ts []T) []error {
+		err := fn(msgs, ts)
+		if err == nil {
+			return nil
+		}
+		errs := make([]error, len(msgs))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}, opts...)
+}
+
+// NewPartialBatchHandler 创建支持部分失败的批量消费handler：
+// fn返回与msgs一一对应的错误，nil表示该条成功，返回nil表示全部成功，长度与msgs不一致时整个批次视为失败
+// 只有失败的消息会按重试策略原地重试，仍然失败的进入重试topic或死信队列
+func NewPartialBatchHandler[T any](l loggerx.Logger, fn func(msgs []*sarama.ConsumerMessage, ts []T) []error, opts ...HandlerOption[T]) *BatchHandler[T] {
+	return &BatchHandler[T]{l: l, fn: fn, options: newHandlerOptions(opts)}
 }
 
 func (b *BatchHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
@@ -30,12 +46,16 @@ func (b *BatchHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 
 func (b *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	msgsCh := claim.Messages()
-	batchSize := b.batchSize
+	batchSize := b.options.batchSize
+	maxBytes := b.options.batchMaxBytes
 	for {
-		ctx, cancel := context.WithTimeout(context.Background(), b.batchDuration)
+		ctx, cancel := context.WithTimeout(context.Background(), b.options.batchDuration)
 		done := false
+		size := 0
 		msgs := make([]*sarama.ConsumerMessage, 0, batchSize)
 		ts := make([]T, 0, batchSize)
+		// dead 已写入死信队列的消息，批次处理完成后再提交，避免越过批次中尚未处理的消息
+		var dead []*sarama.ConsumerMessage
 		for i := 0; i < batchSize && !done; i++ {
 			select {
 			case <-ctx.Done():
@@ -46,35 +66,121 @@ func (b *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, clai
 					// 代表消费者被关闭
 					return nil
 				}
-				var t T
-				err := json.Unmarshal(msg.Value, &t)
+				// 重试消息需等待到期，期间发生再均衡则退出，不提交offset
+				if !b.options.wait(session.Context(), msg) {
+					cancel()
+					return nil
+				}
+				t, err := b.options.codec.Unmarshal(msg.Value)
 				if err != nil {
 					b.l.Error("反序列化失败",
 						loggerx.Error(err),
 						loggerx.String("topic", msg.Topic),
 						loggerx.Int64("partition", int64(msg.Partition)),
 						loggerx.Int64("offset", msg.Offset))
+					ok, err := b.options.deadLetter(b.l, msg, err, 0)
+					if err != nil {
+						// 写入失败时停止消费，不提交该消息及之后的offset，再均衡后重新处理
+						cancel()
+						return err
+					}
+					if ok {
+						dead = append(dead, msg)
+					}
 					continue
 				}
 				msgs = append(msgs, msg)
 				ts = append(ts, t)
+				size += len(msg.Value)
+				if maxBytes > 0 && size >= maxBytes {
+					done = true
+				}
 			}
 		}
 		cancel()
-		if len(msgs) == 0 {
-			continue
+		if len(msgs) > 0 {
+			if ok, err := b.process(session, msgs, ts); !ok {
+				return err
+			}
+		}
+		for _, msg := range dead {
+			session.MarkMessage(msg, "")
 		}
-		err := b.fn(msgs, ts)
-		if err != nil {
-			b.l.Error("调用业务批量接口失败",
-				loggerx.Error(err))
-			// 记录整个批次
+	}
+}
 
-			// 继续往前消费
+// process 处理一个批次，返回false表示需要停止消费该分区：等待重试期间发生再均衡时错误为nil，
+// 失败消息写入重试topic或死信队列失败时返回该错误，此时不提交失败消息及之后的offset
+func (b *BatchHandler[T]) process(session sarama.ConsumerGroupSession, msgs []*sarama.ConsumerMessage, ts []T) (bool, error) {
+	attempts := b.options.attempts(1)
+	// pending 待处理消息在msgs中的下标
+	pending := make([]int, len(msgs))
+	for i := range pending {
+		pending[i] = i
+	}
+	causes := make([]error, len(msgs))
+	subMsgs, subTs := msgs, ts
+	for i := 1; ; i++ {
+		errs := b.fn(subMsgs, subTs)
+		if errs != nil && len(errs) != len(subMsgs) {
+			// 无法确定哪些消息成功，整个批次视为失败
+			err := fmt.Errorf("批量处理返回%d个错误，与消息数%d不一致", len(errs), len(subMsgs))
+			errs = make([]error, len(subMsgs))
+			for j := range errs {
+				errs[j] = err
+			}
 		}
-		for _, msg := range msgs {
-			// 标记最后一个也可以，这样写最安全
-			session.MarkMessage(msg, "")
+		failed := make([]int, 0, len(errs))
+		for j, idx := range pending {
+			if errs != nil && errs[j] != nil {
+				failed = append(failed, idx)
+				causes[idx] = errs[j]
+			}
+		}
+		pending = failed
+		if len(pending) == 0 {
+			break
+		}
+		b.l.Error("调用业务批量接口失败",
+			loggerx.Int64("failed", int64(len(pending))),
+			loggerx.Int64("total", int64(len(msgs))),
+			loggerx.Int64("attempt", int64(i)))
+		if i >= attempts {
+			break
+		}
+		if !b.options.backoff(session.Context(), i) {
+			// 等待期间发生再均衡，整个批次都不提交offset
+			return false, nil
 		}
+		// 只重试失败的消息
+		subMsgs = make([]*sarama.ConsumerMessage, 0, len(pending))
+		subTs = make([]T, 0, len(pending))
+		for _, idx := range pending {
+			subMsgs = append(subMsgs, msgs[idx])
+			subTs = append(subTs, ts[idx])
+		}
+	}
+	// 仍然失败的消息进入重试topic或死信队列，继续往前消费
+	for _, idx := range pending {
+		msg := msgs[idx]
+		b.l.Error("批量处理消息失败",
+			loggerx.Error(causes[idx]),
+			loggerx.String("topic", msg.Topic),
+			loggerx.Int64("partition", int64(msg.Partition)),
+			loggerx.Int64("offset", msg.Offset))
+		if _, err := b.options.fail(b.l, msg, causes[idx], attempts); err != nil {
+			// 只提交该消息之前的offset，该消息及之后的消息再均衡后重新处理
+			b.mark(session, msgs[:idx])
+			return false, err
+		}
+	}
+	b.mark(session, msgs)
+	return true, nil
+}
+
+func (b *BatchHandler[T]) mark(session sarama.ConsumerGroupSession, msgs []*sarama.ConsumerMessage) {
+	for _, msg := range msgs {
+		// 标记最后一个也可以，这样写最安全
+		session.MarkMessage(msg, "")
 	}
 }
@@ -0,0 +1,138 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSession 记录被提交的offset
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	marked []int64
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) Context() context.Context {
+	return context.Background()
+}
+
+// fakeClaim 依次返回给定的消息后关闭
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	ch chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(n int) *fakeClaim {
+	ch := make(chan *sarama.ConsumerMessage, n)
+	for i := 1; i <= n; i++ {
+		ch <- &sarama.ConsumerMessage{Topic: "t", Offset: int64(i), Value: []byte(fmt.Sprintf(`{"n":%d}`, i))}
+	}
+	close(ch)
+	return &fakeClaim{ch: ch}
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.ch
+}
+
+type batchEvent struct {
+	N int `json:"n"`
+}
+
+// dlqProducer 返回死信队列使用的mock producer，results依次为每次发送的结果（nil表示成功）
+func dlqProducer(t *testing.T, results ...error) (*mocks.SyncProducer, *[]int64) {
+	cfg := mocks.NewTestConfig()
+	cfg.Producer.Return.Successes = true
+	producer := mocks.NewSyncProducer(t, cfg)
+	offsets := make([]int64, 0, len(results))
+	for _, res := range results {
+		if res != nil {
+			producer.ExpectSendMessageAndFail(res)
+			continue
+		}
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			for _, h := range msg.Headers {
+				if string(h.Key) == HeaderOriginalOffset {
+					offset, err := strconv.ParseInt(string(h.Value), 10, 64)
+					if err != nil {
+						return err
+					}
+					offsets = append(offsets, offset)
+				}
+			}
+			return nil
+		})
+	}
+	return producer, &offsets
+}
+
+// failEven 偶数消息处理失败
+func failEven(msgs []*sarama.ConsumerMessage, ts []batchEvent) []error {
+	errs := make([]error, len(ts))
+	for i, e := range ts {
+		if e.N%2 == 0 {
+			errs[i] = errors.New("even")
+		}
+	}
+	return errs
+}
+
+func TestBatchHandlerPartialFailure(t *testing.T) {
+	producer, dead := dlqProducer(t, nil, nil)
+	h := NewPartialBatchHandler[batchEvent](&loggerx.NoneLogger{}, failEven, WithDeadLetterQueue[batchEvent](NewDeadLetterQueue(producer, "dlq")), WithBatchSize[batchEvent](5))
+
+	session := &fakeSession{}
+	require.NoError(t, h.ConsumeClaim(session, newFakeClaim(5)))
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, session.marked)
+	assert.Equal(t, []int64{2, 4}, *dead)
+	require.NoError(t, producer.Close())
+}
+
+func TestBatchHandlerMismatchedErrors(t *testing.T) {
+	producer, dead := dlqProducer(t, nil, nil, nil)
+	h := NewPartialBatchHandler[batchEvent](&loggerx.NoneLogger{}, func(msgs []*sarama.ConsumerMessage, ts []batchEvent) []error {
+		// 长度与msgs不一致，无法确定哪些消息成功
+		return []error{nil}
+	}, WithDeadLetterQueue[batchEvent](NewDeadLetterQueue(producer, "dlq")), WithBatchSize[batchEvent](3))
+
+	session := &fakeSession{}
+	require.NoError(t, h.ConsumeClaim(session, newFakeClaim(3)))
+	assert.Equal(t, []int64{1, 2, 3}, session.marked)
+	assert.Equal(t, []int64{1, 2, 3}, *dead)
+	require.NoError(t, producer.Close())
+}
+
+func TestBatchHandlerPublishFailure(t *testing.T) {
+	errDown := errors.New("kafka down")
+	producer, dead := dlqProducer(t, nil, errDown)
+	h := NewPartialBatchHandler[batchEvent](&loggerx.NoneLogger{}, failEven, WithDeadLetterQueue[batchEvent](NewDeadLetterQueue(producer, "dlq")), WithBatchSize[batchEvent](5))
+
+	session := &fakeSession{}
+	// 第4条写入死信队列失败：只提交其之前的offset，并返回错误
+	assert.ErrorIs(t, h.ConsumeClaim(session, newFakeClaim(5)), errDown)
+	assert.Equal(t, []int64{1, 2, 3}, session.marked)
+	assert.Equal(t, []int64{2}, *dead)
+	require.NoError(t, producer.Close())
+}
+
+func TestBatchOptionsIgnoreNonPositive(t *testing.T) {
+	opts := newHandlerOptions([]HandlerOption[batchEvent]{
+		WithBatchSize[batchEvent](-1),
+		WithBatchSize[batchEvent](0),
+		WithBatchDuration[batchEvent](0),
+	})
+	assert.Equal(t, 10, opts.batchSize)
+	assert.Positive(t, opts.batchDuration)
+}
@@ -0,0 +1,55 @@
+package saramax
+
+import (
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 消息编解码器，Handler与Producer共用，保证生产与消费两端格式一致
+type Codec[T any] interface {
+	Marshal(t T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONCodec JSON编解码器（默认）
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(t T) ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var t T
+	err := json.Unmarshal(data, &t)
+	return t, err
+}
+
+// ProtobufCodec protobuf编解码器，T为生成的消息指针类型，如*pb.Article
+type ProtobufCodec[T proto.Message] struct{}
+
+func (ProtobufCodec[T]) Marshal(t T) ([]byte, error) {
+	return proto.Marshal(t)
+}
+
+func (ProtobufCodec[T]) Unmarshal(data []byte) (T, error) {
+	// 生成的消息类型在nil指针上也能取到反射信息，借此创建新实例
+	var zero T
+	t := zero.ProtoReflect().New().Interface().(T)
+	err := proto.Unmarshal(data, t)
+	return t, err
+}
+
+// MsgpackCodec MessagePack编解码器，字段名可用msgpack tag指定
+type MsgpackCodec[T any] struct{}
+
+func (MsgpackCodec[T]) Marshal(t T) ([]byte, error) {
+	return msgpack.Marshal(t)
+}
+
+func (MsgpackCodec[T]) Unmarshal(data []byte) (T, error) {
+	var t T
+	err := msgpack.Unmarshal(data, &t)
+	return t, err
+}
@@ -0,0 +1,95 @@
+package saramax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	articleSchemaV1 = `{"type":"record","name":"Article","fields":[
+		{"name":"id","type":"long"},
+		{"name":"title","type":"string"},
+		{"name":"likes","type":"int"}]}`
+	articleSchemaV2 = `{"type":"record","name":"Article","fields":[
+		{"name":"id","type":"long"},
+		{"name":"title","type":"string"},
+		{"name":"likes","type":"long"},
+		{"name":"views","type":"int","default":7}]}`
+)
+
+type articleV1 struct {
+	ID    int64  `avro:"id" msgpack:"id"`
+	Title string `avro:"title" msgpack:"title"`
+	Likes int32  `avro:"likes" msgpack:"likes"`
+}
+
+type articleV2 struct {
+	ID    int64  `avro:"id"`
+	Title string `avro:"title"`
+	Likes int64  `avro:"likes"`
+	Views int32  `avro:"views"`
+}
+
+func TestAvroCodec(t *testing.T) {
+	registry := NewFileSchemaRegistry(t.TempDir())
+	v1, err := registry.Register(articleSchemaV1)
+	require.NoError(t, err)
+	v2, err := registry.Register(articleSchemaV2)
+	require.NoError(t, err)
+	require.NotEqual(t, v1, v2)
+
+	codec, err := NewAvroCodec[articleV2](registry, v2)
+	require.NoError(t, err)
+	data, err := codec.Marshal(articleV2{ID: 1, Title: "hello", Likes: 3, Views: 42})
+	require.NoError(t, err)
+	// Confluent wire format：魔数0 + schema ID
+	id, _, err := ParseWireFormat(data)
+	require.NoError(t, err)
+	assert.Equal(t, v2, id)
+	got, err := codec.Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, articleV2{ID: 1, Title: "hello", Likes: 3, Views: 42}, got)
+
+	// 旧版本schema写入的消息与读取方schema解析：int提升为long，新增字段使用默认值
+	old, err := NewAvroCodec[articleV1](registry, v1)
+	require.NoError(t, err)
+	data, err = old.Marshal(articleV1{ID: 2, Title: "old", Likes: 5})
+	require.NoError(t, err)
+	got, err = codec.Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, articleV2{ID: 2, Title: "old", Likes: 5, Views: 7}, got)
+
+	// 同一目录的新注册中心从文件中读取schema
+	reloaded, err := NewAvroCodec[articleV2](NewFileSchemaRegistry(registry.dir), v2)
+	require.NoError(t, err)
+	got, err = reloaded.Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, articleV2{ID: 2, Title: "old", Likes: 5, Views: 7}, got)
+
+	_, err = codec.Unmarshal([]byte(`{"id":1}`))
+	assert.ErrorIs(t, err, ErrInvalidWireFormat)
+	_, err = codec.Unmarshal([]byte{0, 0, 0, 0, 99})
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	codec := ProtobufCodec[*wrapperspb.StringValue]{}
+	data, err := codec.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	got, err := codec.Unmarshal(data)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(wrapperspb.String("hello"), got))
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	codec := MsgpackCodec[articleV1]{}
+	data, err := codec.Marshal(articleV1{ID: 1, Title: "hello", Likes: 3})
+	require.NoError(t, err)
+	got, err := codec.Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, articleV1{ID: 1, Title: "hello", Likes: 3}, got)
+}
@@ -1,22 +1,26 @@
 package saramax
 
 import (
-	"encoding/json"
 	"github.com/IBM/sarama"
 	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
 )
 
+// maxAttempts 单条消息的最大处理次数
+const maxAttempts = 3
+
 type HandlerV1[T any] func(msg *sarama.ConsumerMessage, t T) error
 
 type Handler[T any] struct {
-	l  loggerx.Logger
-	fn func(msg *sarama.ConsumerMessage, t T) error
+	l       loggerx.Logger
+	fn      func(msg *sarama.ConsumerMessage, t T) error
+	options handlerOptions[T]
 }
 
-func NewHandler[T any](l loggerx.Logger, fn func(msg *sarama.ConsumerMessage, t T) error) *Handler[T] {
+func NewHandler[T any](l loggerx.Logger, fn func(msg *sarama.ConsumerMessage, t T) error, opts ...HandlerOption[T]) *Handler[T] {
 	return &Handler[T]{
-		l:  l,
-		fn: fn,
+		l:       l,
+		fn:      fn,
+		options: newHandlerOptions(opts),
 	}
 }
 
@@ -30,19 +34,33 @@ func (h Handler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 
 func (h Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	msgs := claim.Messages()
+	// 配置了重试topic时失败消息移出当前分区延迟处理，默认不再原地重试
+	attempts := h.options.attempts(maxAttempts)
 	for msg := range msgs {
-		var t T
-		err := json.Unmarshal(msg.Value, &t)
+		// 重试消息需等待到期，期间发生再均衡则退出，不提交offset
+		if !h.options.wait(session.Context(), msg) {
+			return nil
+		}
+		t, err := h.options.codec.Unmarshal(msg.Value)
 		if err != nil {
 			h.l.Error("反序列化消息失败",
 				loggerx.Error(err),
 				loggerx.String("topic", msg.Topic),
 				loggerx.Int64("partition", int64(msg.Partition)),
 				loggerx.Int64("offset", msg.Offset))
+			// 重试无法修复，直接进入死信队列
+			ok, err := h.options.deadLetter(h.l, msg, err, 0)
+			if err != nil {
+				// 写入失败时停止消费，不提交该消息及之后的offset，再均衡后重新处理
+				return err
+			}
+			if ok {
+				session.MarkMessage(msg, "")
+			}
 			continue
 		}
 		// 在此执行重试
-		for i := 0; i < 3; i++ {
+		for i := 1; i <= attempts; i++ {
 			err = h.fn(msg, t)
 			if err == nil {
 				break
@@ -52,6 +70,10 @@ func (h Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sara
 				loggerx.String("topic", msg.Topic),
 				loggerx.Int64("partition", int64(msg.Partition)),
 				loggerx.Int64("offset", msg.Offset))
+			if i < attempts && !h.options.backoff(session.Context(), i) {
+				// 等待期间发生再均衡，不提交offset
+				return nil
+			}
 		}
 
 		if err != nil {
@@ -60,6 +82,14 @@ func (h Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sara
 				loggerx.String("topic", msg.Topic),
 				loggerx.Int64("partition", int64(msg.Partition)),
 				loggerx.Int64("offset", msg.Offset))
+			ok, err := h.options.fail(h.l, msg, err, attempts)
+			if err != nil {
+				// 写入失败时停止消费，不提交该消息及之后的offset，再均衡后重新处理
+				return err
+			}
+			if ok {
+				session.MarkMessage(msg, "")
+			}
 		} else {
 			session.MarkMessage(msg, "")
 		}
@@ -0,0 +1,163 @@
+package saramax
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+)
+
+// 死信消息携带的header
+const (
+	HeaderOriginalTopic     = "dlq-original-topic"
+	HeaderOriginalPartition = "dlq-original-partition"
+	HeaderOriginalOffset    = "dlq-original-offset"
+	HeaderError             = "dlq-error"
+	HeaderAttempts          = "dlq-attempts"
+)
+
+// deadLetterHeaderPrefix 死信header的公共前缀
+var deadLetterHeaderPrefix = []byte("dlq-")
+
+// ErrNotDeadLetter 消息缺少原始topic header，无法重放
+var ErrNotDeadLetter = errors.New("saramax: 消息不是死信消息")
+
+// DeadLetterQueue 死信队列：处理失败或无法反序列化的消息原样写入死信topic，避免丢失
+type DeadLetterQueue struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewDeadLetterQueue 创建死信队列
+// 注：sarama要求SyncProducer的配置开启Producer.Return.Successes
+func NewDeadLetterQueue(producer sarama.SyncProducer, topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+// Topic 死信topic
+func (d *DeadLetterQueue) Topic() string {
+	return d.topic
+}
+
+// Publish 将消息写入死信topic，保留原key、value和header，
+// 并附加原始topic、分区、offset、错误信息和已尝试次数
+func (d *DeadLetterQueue) Publish(msg *sarama.ConsumerMessage, cause error, attempts int) error {
+	errMsg := ""
+	if cause != nil {
+		errMsg = cause.Error()
+	}
+	// 来自重试topic的消息记录其最初的位置
+	topic, partition, offset := origin(msg)
+	headers := copyHeaders(msg.Headers, 5)
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderOriginalTopic), Value: []byte(topic)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalPartition), Value: []byte(strconv.FormatInt(int64(partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalOffset), Value: []byte(strconv.FormatInt(offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(errMsg)},
+		sarama.RecordHeader{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(attempts))},
+	)
+	_, _, err := d.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   d.topic,
+		Key:     byteEncoder(msg.Key),
+		Value:   byteEncoder(msg.Value),
+		Headers: headers,
+	})
+	return err
+}
+
+// DeadLetterReplayer 死信重放：消费死信topic，将消息移回原始topic重新处理
+// 用法：修复导致失败的问题后，用一个消费者组消费死信topic运行本handler
+type DeadLetterReplayer struct {
+	l        loggerx.Logger
+	producer sarama.SyncProducer
+	filter   func(msg *sarama.ConsumerMessage) bool
+}
+
+// NewDeadLetterReplayer 创建死信重放handler
+// filter为nil时重放全部消息，返回false的消息会被跳过（offset仍然提交）
+func NewDeadLetterReplayer(l loggerx.Logger, producer sarama.SyncProducer, filter func(msg *sarama.ConsumerMessage) bool) *DeadLetterReplayer {
+	return &DeadLetterReplayer{
+		l:        l,
+		producer: producer,
+		filter:   filter,
+	}
+}
+
+func (r *DeadLetterReplayer) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (r *DeadLetterReplayer) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (r *DeadLetterReplayer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if r.filter != nil && !r.filter(msg) {
+			session.MarkMessage(msg, "")
+			continue
+		}
+		err := r.replay(msg)
+		if errors.Is(err, ErrNotDeadLetter) {
+			// 无法确定原始topic，跳过
+			r.l.Warn("跳过非死信消息",
+				loggerx.String("topic", msg.Topic),
+				loggerx.Int64("partition", int64(msg.Partition)),
+				loggerx.Int64("offset", msg.Offset))
+			session.MarkMessage(msg, "")
+			continue
+		}
+		if err != nil {
+			r.l.Error("重放死信消息失败",
+				loggerx.Error(err),
+				loggerx.String("topic", msg.Topic),
+				loggerx.Int64("partition", int64(msg.Partition)),
+				loggerx.Int64("offset", msg.Offset))
+			// 不提交offset，下次启动时重放
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+// replay 去掉死信header后写回原始topic，重试次数从零开始
+func (r *DeadLetterReplayer) replay(msg *sarama.ConsumerMessage) error {
+	topic, ok := header(msg, HeaderOriginalTopic)
+	if !ok || len(topic) == 0 {
+		return ErrNotDeadLetter
+	}
+	_, _, err := r.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   string(topic),
+		Key:     byteEncoder(msg.Key),
+		Value:   byteEncoder(msg.Value),
+		Headers: copyHeaders(msg.Headers, 0),
+	})
+	return err
+}
+
+// copyHeaders 复制业务header，去掉死信与重试header，extra为预留的容量
+// 再次进入死信队列或重试topic时以最新一次为准
+func copyHeaders(src []*sarama.RecordHeader, extra int) []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, len(src)+extra)
+	for _, h := range src {
+		if h == nil || bytes.HasPrefix(h.Key, deadLetterHeaderPrefix) || bytes.HasPrefix(h.Key, retryHeaderPrefix) {
+			continue
+		}
+		headers = append(headers, *h)
+	}
+	return headers
+}
+
+// byteEncoder nil保持为nil，避免把空key当作key参与分区
+func byteEncoder(data []byte) sarama.Encoder {
+	if data == nil {
+		return nil
+	}
+	return sarama.ByteEncoder(data)
+}
@@ -0,0 +1,148 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+)
+
+const (
+	// minConsumeBackoff Consume出错后的最小重试间隔
+	minConsumeBackoff = 100 * time.Millisecond
+	// maxConsumeBackoff Consume出错后的最大重试间隔
+	maxConsumeBackoff = 10 * time.Second
+)
+
+var (
+	// ErrConsumerStarted 重复调用Start
+	ErrConsumerStarted = errors.New("saramax: 消费者已启动")
+	// ErrConsumerStopped Stop后再调用Start
+	ErrConsumerStopped = errors.New("saramax: 消费者已停止")
+)
+
+// GroupConsumer 消费者组运行器，实现Consumer接口：
+// 循环调用ConsumerGroup.Consume（再均衡后自动重新加入，出错时指数退避重试），
+// 并通过loggerx记录消费者组错误
+type GroupConsumer struct {
+	l       loggerx.Logger
+	group   sarama.ConsumerGroup
+	groupID string
+	topics  []string
+	handler sarama.ConsumerGroupHandler
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewGroupConsumer 创建消费者组运行器，handler可以是Handler、BatchHandler或任意sarama.ConsumerGroupHandler
+// 注：sarama的消费者组只能复用client而不能共享，每个GroupConsumer应使用独立的client，
+// Stop不会关闭client，由调用方在Stop之后关闭
+// 需要记录消费者组错误时，client配置应开启Consumer.Return.Errors
+func NewGroupConsumer(l loggerx.Logger, client sarama.Client, groupID string, topics []string, handler sarama.ConsumerGroupHandler) (*GroupConsumer, error) {
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GroupConsumer{
+		l:       l,
+		group:   group,
+		groupID: groupID,
+		topics:  topics,
+		handler: handler,
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// Start 在后台启动消费，立即返回
+func (c *GroupConsumer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return ErrConsumerStopped
+	}
+	if c.started {
+		return ErrConsumerStarted
+	}
+	c.started = true
+	c.wg.Add(2)
+	go c.consume()
+	go c.logErrors()
+	return nil
+}
+
+// Stop 停止消费：等待handler退出ConsumeClaim、提交已标记的offset后离开消费者组
+// 注：handler应在claim.Messages()关闭或session.Context()结束时及时返回
+func (c *GroupConsumer) Stop() error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
+	c.mu.Unlock()
+	c.cancel()
+	// 等待Consume返回，此时本轮session已结束并提交offset
+	c.wg.Wait()
+	return c.group.Close()
+}
+
+// consume 消费循环，直到Stop
+func (c *GroupConsumer) consume() {
+	defer c.wg.Done()
+	interval := minConsumeBackoff
+	for {
+		// 每次再均衡后Consume都会返回，需要重新调用以加入新一代消费者组
+		err := c.group.Consume(c.ctx, c.topics, c.handler)
+		if c.ctx.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return
+		}
+		if err == nil {
+			interval = minConsumeBackoff
+			continue
+		}
+		c.l.Error("消费者组消费失败，准备重试",
+			loggerx.Error(err),
+			loggerx.String("group", c.groupID),
+			loggerx.String("interval", interval.String()))
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		// 指数退避
+		interval *= 2
+		if interval > maxConsumeBackoff {
+			interval = maxConsumeBackoff
+		}
+	}
+}
+
+// logErrors 记录消费者组错误，直到Stop
+// 需要持续读取Errors()，否则开启Consumer.Return.Errors时会阻塞消费
+func (c *GroupConsumer) logErrors() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.ctx.Done():
+			// 剩余的错误由group.Close负责排空
+			return
+		case err, ok := <-c.group.Errors():
+			if !ok {
+				return
+			}
+			c.l.Error("消费者组错误",
+				loggerx.Error(err),
+				loggerx.String("group", c.groupID))
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package saramax
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+)
+
+// handlerOptions Handler与BatchHandler共用的配置
+type handlerOptions[T any] struct {
+	codec  Codec[T]
+	dlq    *DeadLetterQueue
+	retry  *RetryTopics
+	policy *RetryPolicy
+
+	// 以下仅BatchHandler有效
+	batchSize     int
+	batchDuration time.Duration
+	batchMaxBytes int
+}
+
+// RetryPolicy 原地重试策略，原地重试用完后再进入重试topic或死信队列
+type RetryPolicy struct {
+	MaxAttempts int           // 最大处理次数（含首次），小于1时按1处理
+	Backoff     time.Duration // 首次重试前的等待时间，之后每次翻倍，为0时立即重试
+}
+
+// HandlerOption 消费者handler配置选项
+type HandlerOption[T any] func(opts *handlerOptions[T])
+
+// WithHandlerCodec 设置解码器，需与生产端使用的编解码器一致，默认JSONCodec
+func WithHandlerCodec[T any](codec Codec[T]) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		opts.codec = codec
+	}
+}
+
+// WithDeadLetterQueue 设置死信队列：重试耗尽或无法反序列化的消息写入死信topic后再提交offset
+// 写入失败时ConsumeClaim返回错误并停止消费该分区，未设置时仅记录日志
+func WithDeadLetterQueue[T any](dlq *DeadLetterQueue) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		opts.dlq = dlq
+	}
+}
+
+// WithRetryTopics 设置分级延迟重试：处理失败的消息不再原地重试，而是写入下一级重试topic，
+// 各级都失败后进入死信队列。handler需要同时订阅主topic与retry.Topics()
+func WithRetryTopics[T any](retry *RetryTopics) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		opts.retry = retry
+	}
+}
+
+// WithRetryPolicy 设置原地重试策略
+// 默认Handler处理3次、BatchHandler处理1次，配置了重试topic时默认只处理1次
+func WithRetryPolicy[T any](policy RetryPolicy) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		opts.policy = &policy
+	}
+}
+
+// WithBatchSize 设置每批最大消息数（仅BatchHandler有效），默认10，非正数时忽略
+func WithBatchSize[T any](size int) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		if size > 0 {
+			opts.batchSize = size
+		}
+	}
+}
+
+// WithBatchDuration 设置凑批的最长等待时间（仅BatchHandler有效），默认1秒，非正数时忽略
+func WithBatchDuration[T any](d time.Duration) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		if d > 0 {
+			opts.batchDuration = d
+		}
+	}
+}
+
+// WithBatchMaxBytes 设置每批消息value的总字节数上限（仅BatchHandler有效），默认不限制
+// 累计达到上限时立即处理当前批次，因此最后一条消息可能使批次略超上限
+func WithBatchMaxBytes[T any](n int) HandlerOption[T] {
+	return func(opts *handlerOptions[T]) {
+		opts.batchMaxBytes = n
+	}
+}
+
+func newHandlerOptions[T any](opts []HandlerOption[T]) handlerOptions[T] {
+	options := handlerOptions[T]{
+		codec:         JSONCodec[T]{},
+		batchSize:     10,
+		batchDuration: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// attempts 本轮原地处理的最大次数，def为未配置重试策略与重试topic时的默认值
+func (opts *handlerOptions[T]) attempts(def int) int {
+	switch {
+	case opts.policy != nil:
+		return max(opts.policy.MaxAttempts, 1)
+	case opts.retry != nil:
+		return 1
+	default:
+		return def
+	}
+}
+
+// backoff 第attempt次处理失败后、下一次重试前等待，返回false表示ctx已结束
+func (opts *handlerOptions[T]) backoff(ctx context.Context, attempt int) bool {
+	if opts.policy == nil || opts.policy.Backoff <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(opts.policy.Backoff << min(attempt-1, 16))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// fail 处理失败：优先写入下一级重试topic，重试层级用完后写入死信队列
+// attempts为本轮的处理次数，返回是否可以提交offset；写入失败时返回错误，调用方需停止消费该分区，
+// 否则之后的offset提交会越过这条消息
+func (opts *handlerOptions[T]) fail(l loggerx.Logger, msg *sarama.ConsumerMessage, cause error, attempts int) (bool, error) {
+	if opts.retry != nil {
+		ok, err := opts.retry.Publish(msg, cause)
+		if err != nil {
+			l.Error("写入重试topic失败",
+				loggerx.Error(err),
+				loggerx.String("topic", msg.Topic),
+				loggerx.Int64("partition", int64(msg.Partition)),
+				loggerx.Int64("offset", msg.Offset))
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return opts.deadLetter(l, msg, cause, retryCount(msg)+attempts)
+}
+
+// wait 等待重试消息到期，返回false表示ctx已结束
+func (opts *handlerOptions[T]) wait(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	if opts.retry == nil {
+		return true
+	}
+	return opts.retry.wait(ctx, msg)
+}
+
+// deadLetter 将消息写入死信队列，返回是否写入成功（成功后才能提交offset）
+// 未设置死信队列时返回false，写入失败时返回错误
+func (opts *handlerOptions[T]) deadLetter(l loggerx.Logger, msg *sarama.ConsumerMessage, cause error, attempts int) (bool, error) {
+	if opts.dlq == nil {
+		return false, nil
+	}
+	err := opts.dlq.Publish(msg, cause, attempts)
+	if err != nil {
+		l.Error("写入死信队列失败",
+			loggerx.Error(err),
+			loggerx.String("topic", msg.Topic),
+			loggerx.Int64("partition", int64(msg.Partition)),
+			loggerx.Int64("offset", msg.Offset))
+		return false, err
+	}
+	l.Warn("消息已写入死信队列",
+		loggerx.Error(cause),
+		loggerx.String("topic", msg.Topic),
+		loggerx.Int64("partition", int64(msg.Partition)),
+		loggerx.Int64("offset", msg.Offset),
+		loggerx.String("dlq", opts.dlq.Topic()))
+	return true, nil
+}
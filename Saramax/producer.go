@@ -0,0 +1,118 @@
+package saramax
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+)
+
+// producerOptions Producer与AsyncProducer共用的配置
+type producerOptions[T any] struct {
+	codec    Codec[T]
+	keyFunc  func(t T) string
+	callback func(result DeliveryResult[T])
+	results  int // 结果通道容量，0表示不创建结果通道
+}
+
+// ProducerOption 生产者配置选项
+type ProducerOption[T any] func(opts *producerOptions[T])
+
+// WithCodec 设置编码器，需与消费端Handler使用的编解码器一致，默认JSONCodec
+func WithCodec[T any](codec Codec[T]) ProducerOption[T] {
+	return func(opts *producerOptions[T]) {
+		opts.codec = codec
+	}
+}
+
+// WithKeyFunc 设置分区键提取函数，Send传入的key为空时使用T中提取的key，
+// 使同一业务实体（如同一用户）的消息进入同一分区、保持顺序
+func WithKeyFunc[T any](fn func(t T) string) ProducerOption[T] {
+	return func(opts *producerOptions[T]) {
+		opts.keyFunc = fn
+	}
+}
+
+func newProducerOptions[T any](opts []ProducerOption[T]) producerOptions[T] {
+	options := producerOptions[T]{codec: JSONCodec[T]{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// Producer 基于sarama.SyncProducer的泛型同步生产者
+type Producer[T any] struct {
+	producer sarama.SyncProducer
+	options  producerOptions[T]
+}
+
+// NewProducer 创建同步生产者
+// 注：sarama要求SyncProducer的配置开启Producer.Return.Successes
+func NewProducer[T any](producer sarama.SyncProducer, opts ...ProducerOption[T]) *Producer[T] {
+	return &Producer[T]{
+		producer: producer,
+		options:  newProducerOptions(opts),
+	}
+}
+
+// Send 同步发送一条消息，返回写入的分区和offset
+func (p *Producer[T]) Send(ctx context.Context, topic string, key string, t T, headers map[string]string) (int32, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	msg, err := p.options.message(topic, p.options.key(key, t), t, headers)
+	if err != nil {
+		return 0, 0, err
+	}
+	return p.producer.SendMessage(msg)
+}
+
+// SendBatch 同步批量发送，分区键由WithKeyFunc提取
+// 部分消息失败时返回sarama.ProducerErrors，可从中取出失败的消息
+func (p *Producer[T]) SendBatch(ctx context.Context, topic string, ts []T, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	msgs := make([]*sarama.ProducerMessage, 0, len(ts))
+	for _, t := range ts {
+		msg, err := p.options.message(topic, p.options.key("", t), t, headers)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	return p.producer.SendMessages(msgs)
+}
+
+// Close 关闭底层生产者
+func (p *Producer[T]) Close() error {
+	return p.producer.Close()
+}
+
+// key 显式传入的key优先，为空时从T中提取
+func (opts *producerOptions[T]) key(key string, t T) string {
+	if key == "" && opts.keyFunc != nil {
+		return opts.keyFunc(t)
+	}
+	return key
+}
+
+// message 编码并组装sarama消息
+func (opts *producerOptions[T]) message(topic string, key string, t T, headers map[string]string) (*sarama.ProducerMessage, error) {
+	value, err := opts.codec.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	// key为空时由分区器随机（或轮询）选择分区
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return msg, nil
+}
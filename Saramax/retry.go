@@ -0,0 +1,162 @@
+package saramax
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// 重试消息携带的header
+const (
+	HeaderRetryOriginalTopic     = "retry-original-topic"
+	HeaderRetryOriginalPartition = "retry-original-partition"
+	HeaderRetryOriginalOffset    = "retry-original-offset"
+	HeaderRetryCount             = "retry-count" // 已进入重试topic的次数
+	HeaderRetryDue               = "retry-due"   // 可以重新处理的时间，unix毫秒
+	HeaderRetryError             = "retry-error"
+)
+
+// retryHeaderPrefix 重试header的公共前缀
+var retryHeaderPrefix = []byte("retry-")
+
+// RetryTier 一级延迟重试：进入Topic的消息在Delay之后才会被重新处理
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
+}
+
+// DefaultRetryTiers 默认重试层级：<topic>-retry-5s、<topic>-retry-1m、<topic>-retry-10m
+func DefaultRetryTiers(topic string) []RetryTier {
+	return []RetryTier{
+		{Topic: topic + "-retry-5s", Delay: 5 * time.Second},
+		{Topic: topic + "-retry-1m", Delay: time.Minute},
+		{Topic: topic + "-retry-10m", Delay: 10 * time.Minute},
+	}
+}
+
+// RetryTopics 分级延迟重试：处理失败的消息依次进入各级重试topic，延迟后重新处理，
+// 全部层级失败后进入死信队列（如果配置了的话）。失败消息移出主分区，不再阻塞后续消息
+// 每一级使用独立的topic且延迟固定，因此topic内消息的到期时间与offset顺序一致，
+// 消费时只需等待队首消息到期
+// 用法：handler同时订阅主topic与Topics()返回的全部重试topic
+type RetryTopics struct {
+	producer sarama.SyncProducer
+	tiers    []RetryTier
+	now      func() time.Time
+}
+
+// NewRetryTopics 创建分级延迟重试
+// 注：sarama要求SyncProducer的配置开启Producer.Return.Successes
+func NewRetryTopics(producer sarama.SyncProducer, tiers ...RetryTier) *RetryTopics {
+	return &RetryTopics{
+		producer: producer,
+		tiers:    tiers,
+		now:      time.Now,
+	}
+}
+
+// Topics 全部重试topic，需与主topic一起订阅
+func (r *RetryTopics) Topics() []string {
+	topics := make([]string, 0, len(r.tiers))
+	for _, tier := range r.tiers {
+		topics = append(topics, tier.Topic)
+	}
+	return topics
+}
+
+// Publish 将消息写入下一级重试topic，返回false表示重试层级已用完
+func (r *RetryTopics) Publish(msg *sarama.ConsumerMessage, cause error) (bool, error) {
+	count := retryCount(msg)
+	if count >= len(r.tiers) {
+		return false, nil
+	}
+	tier := r.tiers[count]
+	errMsg := ""
+	if cause != nil {
+		errMsg = cause.Error()
+	}
+	topic, partition, offset := origin(msg)
+	headers := copyHeaders(msg.Headers, 6)
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderRetryOriginalTopic), Value: []byte(topic)},
+		sarama.RecordHeader{Key: []byte(HeaderRetryOriginalPartition), Value: []byte(strconv.FormatInt(int64(partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderRetryOriginalOffset), Value: []byte(strconv.FormatInt(offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderRetryCount), Value: []byte(strconv.Itoa(count + 1))},
+		sarama.RecordHeader{Key: []byte(HeaderRetryDue), Value: []byte(strconv.FormatInt(r.now().Add(tier.Delay).UnixMilli(), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderRetryError), Value: []byte(errMsg)},
+	)
+	_, _, err := r.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   tier.Topic,
+		Key:     byteEncoder(msg.Key),
+		Value:   byteEncoder(msg.Value),
+		Headers: headers,
+	})
+	return err == nil, err
+}
+
+// wait 等待重试消息到期，非重试消息立即返回；ctx结束（如发生再均衡）时返回false
+func (r *RetryTopics) wait(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	value, ok := header(msg, HeaderRetryDue)
+	if !ok {
+		return true
+	}
+	due, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return true
+	}
+	d := time.UnixMilli(due).Sub(r.now())
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// OriginalTopic 消息最初写入的topic，重试消息返回主topic
+func OriginalTopic(msg *sarama.ConsumerMessage) string {
+	topic, _, _ := origin(msg)
+	return topic
+}
+
+// origin 消息最初写入的topic、分区和offset
+func origin(msg *sarama.ConsumerMessage) (string, int32, int64) {
+	topic, ok := header(msg, HeaderRetryOriginalTopic)
+	if !ok {
+		return msg.Topic, msg.Partition, msg.Offset
+	}
+	partition, _ := header(msg, HeaderRetryOriginalPartition)
+	offset, _ := header(msg, HeaderRetryOriginalOffset)
+	p, _ := strconv.ParseInt(string(partition), 10, 32)
+	o, _ := strconv.ParseInt(string(offset), 10, 64)
+	return string(topic), int32(p), o
+}
+
+// retryCount 消息已进入重试topic的次数
+func retryCount(msg *sarama.ConsumerMessage) int {
+	value, ok := header(msg, HeaderRetryCount)
+	if !ok {
+		return 0
+	}
+	count, _ := strconv.Atoi(string(value))
+	return count
+}
+
+// header 读取header，同名时取最后一个
+func header(msg *sarama.ConsumerMessage, key string) ([]byte, bool) {
+	var value []byte
+	found := false
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			value, found = h.Value, true
+		}
+	}
+	return value, found
+}
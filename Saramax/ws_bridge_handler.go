@@ -0,0 +1,53 @@
+package saramax
+
+import (
+	"encoding/json"
+	"github.com/IBM/sarama"
+	"github.com/LEILEI0628/GinPro/GinX/websocket"
+	loggerx "github.com/LEILEI0628/GinPro/middleware/logger"
+)
+
+// WsEnvelope 推送给WebSocket客户端的Kafka消息
+type WsEnvelope struct {
+	Target websocket.TargetKind `json:"target"`         // all / room / user
+	Room   string               `json:"room,omitempty"` // Target为room时有效
+	UID    int64                `json:"uid,omitempty"`  // Target为user时有效
+	// Type 事件类型，非空时Payload作为data包装为websocket.Event信封，为空时原样推送Payload
+	Type    string          `json:"type,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// NewWsBridgeHandler 创建Kafka到WebSocket的桥接消费者：
+// 消费WsEnvelope并投递到本实例的WebSocket连接，投递完成后才提交offset
+// 注：每个实例只投递到自己的连接，因此每个实例都需要消费全量消息，即每个实例使用独立的消费者组ID
+func NewWsBridgeHandler(l loggerx.Logger, manager *websocket.Manager, opts ...HandlerOption[WsEnvelope]) *Handler[WsEnvelope] {
+	return NewHandler[WsEnvelope](l, func(msg *sarama.ConsumerMessage, env WsEnvelope) error {
+		switch env.Target {
+		case websocket.TargetAll, websocket.TargetRoom, websocket.TargetUser:
+		default:
+			// 重试无法修复，记录后跳过
+			l.Error("未知的WebSocket投递目标",
+				loggerx.String("target", string(env.Target)),
+				loggerx.String("topic", msg.Topic),
+				loggerx.Int64("partition", int64(msg.Partition)),
+				loggerx.Int64("offset", msg.Offset))
+			return nil
+		}
+		data := []byte(env.Payload)
+		if env.Type != "" {
+			var err error
+			data, err = json.Marshal(&websocket.Event{Type: env.Type, Data: env.Payload})
+			if err != nil {
+				return err
+			}
+		}
+		// 只投递到本实例，不经过消息总线
+		manager.DeliverLocal(&websocket.Delivery{
+			Target: env.Target,
+			Room:   env.Room,
+			UID:    env.UID,
+			Data:   data,
+		})
+		return nil
+	}, opts...)
+}